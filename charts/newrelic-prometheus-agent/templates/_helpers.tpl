@@ -42,6 +42,14 @@ common:
 {{- end -}}
 {{- end -}}
 
+{{- /* the metric type overrides of the configurator catalog are enabled, the ones set by the user take precedence  */ -}}
+{{- if .Values.metric_type_override -}}
+  {{- if .Values.metric_type_override.enabled -}}
+    {{- $metricTypeOverrides := get $tmp "metric_type_overrides" | default (dict) -}}
+    {{- $_ := set $tmp "metric_type_overrides" (mustMerge $metricTypeOverrides (dict "catalog_version" 1 "exporters" (list "cockroach"))) -}}
+  {{- end -}}
+{{- end -}}
+
 {{- /* Remove proxy_url if proxyFromSecret.enabled is true */ -}}
 {{- if .Values.config.proxyFromSecret.enabled -}}
   {{- $_ := unset $tmp "proxy_url" -}}
@@ -53,7 +61,7 @@ common:
 
 {{- end -}}
 
-{{- /* it builds the extra_write_relabel_configs configuration merging: lowdatamode, user ones, and metrictyperelabeldefaults  */ -}}
+{{- /* it builds the extra_write_relabel_configs configuration merging: lowdatamode and user ones  */ -}}
 {{- define "newrelic-prometheus.configurator.extra_write_relabel_configs" -}}
 
 {{- $extra_write_relabel_configs := list  -}}
@@ -62,13 +70,6 @@ common:
   {{- $extra_write_relabel_configs = concat $extra_write_relabel_configs $lowDataModeRelabelConfig.low_data_mode -}}
 {{- end -}}
 
-{{- if .Values.metric_type_override -}}
-  {{- if .Values.metric_type_override.enabled -}}
-    {{- $metricTypeOverride := .Files.Get "static/metrictyperelabeldefaults.yaml" | fromYaml -}}
-    {{- $extra_write_relabel_configs = concat $extra_write_relabel_configs $metricTypeOverride.metrics_type_relabel -}}
-  {{- end -}}
-{{- end -}}
-
 {{- if .Values.config -}}
 {{- if .Values.config.newrelic_remote_write -}}
   {{- /* it concatenates the defined 'extra_write_relabel_configs' to the ones defined in lowDataMode  */ -}}
@@ -4,13 +4,26 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
+	"syscall"
 
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/atomicfile"
 	"github.com/newrelic/newrelic-prometheus-configurator/internal/configurator"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/redact"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/remotewrite"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/watcher"
 
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
@@ -22,6 +35,16 @@ const (
 	nrConfigErrCode = iota + 1
 	prometheusConfigErrCode
 	parseErrCode
+	watchErrCode
+	invalidPrometheusConfigErrCode
+	checksumErrCode
+	credentialsFileErrCode
+
+	prometheusConfigPerm = 0o644
+	// credentialsFilePerm keeps the license key readable only by the user running the configurator and Prometheus.
+	credentialsFilePerm = 0o600
+	// checksumSuffix is appended to the output path to name the file holding its checksum.
+	checksumSuffix = ".sha256"
 )
 
 var (
@@ -34,11 +57,41 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case validateCommand:
+			os.Exit(runValidate(os.Args[2:]))
+		case explainCommand:
+			os.Exit(runExplain(os.Args[2:]))
+		case previewCommand:
+			os.Exit(runPreview(os.Args[2:]))
+		case testCommand:
+			os.Exit(runTest(os.Args[2:]))
+		case schemaCommand:
+			os.Exit(runSchema(os.Args[2:]))
+		case metricsCommand:
+			os.Exit(runMetrics(os.Args[2:]))
+		}
+	}
+
 	logger := log.StandardLogger()
+	// Secrets are added as the config is loaded, so they are masked in any log entry after that.
+	secrets := redact.NewHook()
+	logger.AddHook(secrets)
 
-	nrConfigFlag := flag.String("input", "", "Input file to load the configuration from, defaults to stdin.")
+	var input inputFlags
+	input.register(flag.CommandLine)
 	prometheusConfigFlag := flag.String("output", "", "Output file to use as prometheus config, defaults to stdout.")
 	verboseLog := flag.Bool("verbose", false, "Sets log level to debug.")
+	lenient := flag.Bool("lenient", false, "Logs unknown fields in the input as warnings instead of failing.")
+	suffixDuplicateJobNames := flag.Bool("suffix-duplicate-job-names", false, "Renames the scrape jobs whose name is already used appending -2, -3, ... instead of failing.")
+	credentialsFileFlag := flag.String("credentials-file", "", "File where the license key is written, 0600, for Prometheus to read it instead of inlining the key in the output.")
+	emitChecksum := flag.Bool("emit-checksum", false, "Writes the SHA-256 checksum of the output next to it, in the output path with the .sha256 suffix.")
+	printEffectiveConfig := flag.Bool("print-effective-config", false, "Prints the configuration resolved from the input files and the environment, with secrets masked, and exits.")
+	watch := flag.Bool("watch", false, "Keeps running, rebuilding the output when the configuration sources change and reloading Prometheus.")
+	watchInterval := flag.Duration("watch-interval", watcher.DefaultInterval, "Time between checks of the configuration sources in watch mode.")
+	watchMaxBackoff := flag.Duration("watch-max-backoff", watcher.DefaultMaxBackoff, "Maximum time between checks in watch mode when they keep failing.")
+	reloadURL := flag.String("reload-url", watcher.DefaultReloadURL, "Prometheus endpoint called in watch mode after the output changes, empty to disable reloads.")
 	flag.Parse()
 
 	if *verboseLog {
@@ -54,39 +107,215 @@ func main() {
 		gitCommit,
 		buildDate)
 
-	nrConfig, err := readNrConfig(*nrConfigFlag)
+	if *emitChecksum && *prometheusConfigFlag == "" {
+		logger.Errorf("Emitting the checksum requires the output file to be set")
+		os.Exit(checksumErrCode)
+	}
+
+	buildOptions := []configurator.BuildOption{
+		configurator.WithSuffixedDuplicateJobNames(*suffixDuplicateJobNames),
+		configurator.WithCredentialsFile(*credentialsFileFlag),
+	}
+
+	// Printing the effective config is a one-off inspection, so it takes precedence over watch mode.
+	if *watch && !*printEffectiveConfig {
+		if (len(input.paths) == 0 && input.dir == "") || *prometheusConfigFlag == "" {
+			logger.Errorf("Watch mode requires both the input and the output files to be set")
+			os.Exit(watchErrCode)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		watcherConfig := watcher.Config{
+			Interval:   *watchInterval,
+			MaxBackoff: *watchMaxBackoff,
+			ReloadURL:  *reloadURL,
+		}
+
+		render := func() ([]watcher.File, error) {
+			return renderFiles(renderConfig{
+				nrConfigPaths:        input.paths,
+				inputDir:             input.dir,
+				prometheusConfigPath: *prometheusConfigFlag,
+				credentialsFilePath:  *credentialsFileFlag,
+				lenient:              *lenient,
+				emitChecksum:         *emitChecksum,
+				buildOptions:         buildOptions,
+				secrets:              secrets,
+			}, logger)
+		}
+
+		watched := slices.Clone(input.paths)
+		if input.dir != "" {
+			watched = append(watched, input.dir)
+		}
+
+		logger.Infof("Watching %s for changes", strings.Join(watched, ", "))
+		watcher.New(watcherConfig, render, logger).Run(ctx)
+
+		return
+	}
+
+	nrConfig, err := readNrConfig(input.paths, input.dir, *lenient, logger)
 	if err != nil {
 		logger.Errorf("Error loading the nrConfig: %s", err)
 		os.Exit(nrConfigErrCode)
 	}
 
-	prometheusConfig, err := configurator.BuildPromConfig(nrConfig)
+	secrets.Add(redact.Secrets(nrConfig)...)
+
+	data, err := buildPromConfig(nrConfig, buildOptions...)
+	// Building expands the values defined by environment variables or files, which may hold more secrets.
+	secrets.Add(redact.Secrets(nrConfig)...)
+
+	if errors.Is(err, configurator.ErrInvalidPromConfig) {
+		logger.Errorf("Error validating the prometheusConfig, it is not written: %s", err)
+		os.Exit(invalidPrometheusConfigErrCode)
+	}
+
 	if err != nil {
 		logger.Errorf("Error parsing the configuration: %s", err)
 		os.Exit(parseErrCode)
 	}
 
-	if err := writePromConfig(*prometheusConfigFlag, prometheusConfig); err != nil {
+	if *printEffectiveConfig {
+		if err := writeEffectiveConfig(os.Stdout, nrConfig); err != nil {
+			logger.Errorf("Error printing the effective configuration: %s", err)
+			os.Exit(parseErrCode)
+		}
+
+		return
+	}
+
+	// The key is written first, so Prometheus finds it when loading the config referencing it.
+	if *credentialsFileFlag != "" {
+		for _, credentials := range credentialsFiles(*credentialsFileFlag, nrConfig) {
+			if err := atomicfile.Write(credentials.Path, credentials.Data, credentials.Perm); err != nil {
+				logger.Errorf("Error writing the credentials file: %s", err)
+				os.Exit(credentialsFileErrCode)
+			}
+		}
+	}
+
+	if err := writePromConfig(*prometheusConfigFlag, data); err != nil {
 		logger.Errorf("Error writing the prometheusConfig configuration: %s", err)
 		os.Exit(prometheusConfigErrCode)
 	}
+
+	if *emitChecksum {
+		checksum := checksumFile(*prometheusConfigFlag, data)
+		if err := atomicfile.Write(checksum.Path, checksum.Data, checksum.Perm); err != nil {
+			logger.Errorf("Error writing the checksum of the prometheusConfig: %s", err)
+			os.Exit(checksumErrCode)
+		}
+	}
 }
 
-func readNrConfig(nrConfigPath string) (*configurator.NrConfig, error) {
-	nrConfig := &configurator.NrConfig{}
+// readNrConfig loads the nrConfig merging the input files, failing on unknown fields unless lenient is set, then they
+// are logged as warnings. The New Relic remote write settings not supported by its endpoint are logged as warnings too.
+func readNrConfig(nrConfigPaths []string, inputDir string, lenient bool, logger *log.Logger) (*configurator.NrConfig, error) {
+	fragments, err := readFragments(nrConfigPaths, inputDir)
+	if err != nil {
+		return nil, err
+	}
 
-	if nrConfigPath == "" {
-		data, err := io.ReadAll(os.Stdin)
+	nrConfig, unknownFields, err := configurator.LoadFragments(fragments, lenient)
+	if err != nil {
+		return nil, err //nolint: wrapcheck
+	}
+
+	for _, f := range unknownFields {
+		logger.Warnf("Ignoring nrConfig field, %s", f)
+	}
+
+	for _, setting := range nrConfig.RemoteWrite.UnsupportedSettings() {
+		logger.Warnf("Setting newrelic_remote_write.%s is not supported: %s", setting.Field, setting.Reason)
+	}
+
+	return nrConfig, nil
+}
+
+// inputFlags are the flags selecting the input files, shared by the main command and the subcommands loading the
+// nrConfig.
+type inputFlags struct {
+	paths stringsFlag
+	dir   string
+}
+
+// register adds the input flags to the flag set.
+func (i *inputFlags) register(flags *flag.FlagSet) {
+	flags.Var(&i.paths, "input", "Input file to load the configuration from, defaults to stdin. It can be set several times to merge the files.")
+	flags.StringVar(&i.dir, "input-dir", "", "Directory whose .yaml and .yml files are merged into the configuration, in name order after the input files.")
+}
+
+// loadInput loads the nrConfig merging the input files for the subcommands inspecting it, unknown fields are ignored
+// when lenient is set.
+func loadInput(input inputFlags, lenient bool) (*configurator.NrConfig, error) {
+	fragments, err := readFragments(input.paths, input.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	nrConfig, _, err := configurator.LoadFragments(fragments, lenient)
+	if err != nil {
+		return nil, err //nolint: wrapcheck
+	}
+
+	return nrConfig, nil
+}
+
+// readFragments reads the input files followed by the .yaml and .yml files of the input directory sorted by name, or
+// stdin if none is set.
+func readFragments(nrConfigPaths []string, inputDir string) ([]configurator.Fragment, error) {
+	paths := slices.Clone(nrConfigPaths)
+
+	if inputDir != "" {
+		entries, err := os.ReadDir(inputDir)
 		if err != nil {
-			return nil, fmt.Errorf("could not read from stdin: %w", err)
+			return nil, fmt.Errorf("the input directory could not be read: %w", err)
 		}
 
-		err = yaml.Unmarshal(data, nrConfig)
+		// Entries are returned sorted by name.
+		for _, entry := range entries {
+			if ext := filepath.Ext(entry.Name()); !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+				paths = append(paths, filepath.Join(inputDir, entry.Name()))
+			}
+		}
+	}
+
+	if len(paths) == 0 {
+		data, err := readInput("")
+		if err != nil {
+			return nil, err
+		}
+
+		return []configurator.Fragment{{Path: "stdin", Data: data}}, nil
+	}
+
+	fragments := make([]configurator.Fragment, 0, len(paths))
+
+	for _, path := range paths {
+		data, err := readInput(path)
+		if err != nil {
+			return nil, err
+		}
+
+		fragments = append(fragments, configurator.Fragment{Path: path, Data: data})
+	}
+
+	return fragments, nil
+}
+
+// readInput reads the content of the nrConfig file, or stdin if no path is provided.
+func readInput(nrConfigPath string) ([]byte, error) {
+	if nrConfigPath == "" {
+		data, err := io.ReadAll(os.Stdin)
 		if err != nil {
-			return nil, fmt.Errorf("yaml nrConfig could not be loaded: %w", err)
+			return nil, fmt.Errorf("could not read from stdin: %w", err)
 		}
 
-		return nrConfig, nil
+		return data, nil
 	}
 
 	fileReader, err := os.Open(nrConfigPath)
@@ -103,38 +332,130 @@ func readNrConfig(nrConfigPath string) (*configurator.NrConfig, error) {
 		return nil, fmt.Errorf("could not close the nrConfig file: %w", err)
 	}
 
-	err = yaml.Unmarshal(data, nrConfig)
+	return data, nil
+}
+
+// renderConfig holds the settings used by renderFiles.
+type renderConfig struct {
+	nrConfigPaths        []string
+	inputDir             string
+	prometheusConfigPath string
+	credentialsFilePath  string
+	lenient              bool
+	emitChecksum         bool
+	buildOptions         []configurator.BuildOption
+	secrets              *redact.Hook
+}
+
+// renderFiles builds the prometheus config from the nrConfig files and returns the files to be kept up to date in watch
+// mode. The credentials files go first, so Prometheus finds the key when reloading the config referencing it.
+func renderFiles(config renderConfig, logger *log.Logger) ([]watcher.File, error) {
+	nrConfig, err := readNrConfig(config.nrConfigPaths, config.inputDir, config.lenient, logger)
 	if err != nil {
-		return nil, fmt.Errorf("yaml nrConfig could not be loaded: %w", err)
+		return nil, fmt.Errorf("loading the nrConfig: %w", err)
 	}
 
-	return nrConfig, nil
+	secrets := redact.Secrets(nrConfig)
+	config.secrets.Add(secrets...)
+
+	data, err := buildPromConfig(nrConfig, config.buildOptions...)
+	secrets = append(secrets, redact.Secrets(nrConfig)...)
+	config.secrets.Add(secrets...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the secrets of the current config are kept, so the ones rotated out are not accumulated while watching.
+	config.secrets.Set(secrets...)
+
+	var files []watcher.File
+	if config.credentialsFilePath != "" {
+		files = append(files, credentialsFiles(config.credentialsFilePath, nrConfig)...)
+	}
+
+	files = append(files, watcher.File{Path: config.prometheusConfigPath, Data: data, Perm: prometheusConfigPerm})
+	if config.emitChecksum {
+		files = append(files, checksumFile(config.prometheusConfigPath, data))
+	}
+
+	return files, nil
+}
+
+// credentialsFiles returns the files holding the license keys of the nrConfig, which must be already expanded by
+// building it: the one of the default account, if it receives metrics, and the one of each additional account.
+func credentialsFiles(path string, nrConfig *configurator.NrConfig) []watcher.File {
+	var files []watcher.File
+
+	if nrConfig.RemoteWrite.CatchAllEnabled() {
+		files = append(files, watcher.File{Path: path, Data: []byte(nrConfig.RemoteWrite.LicenseKey), Perm: credentialsFilePerm})
+	}
+
+	for _, account := range nrConfig.RemoteWrite.Accounts {
+		files = append(files, watcher.File{
+			Path: remotewrite.AccountCredentialsFile(path, account.Name),
+			Data: []byte(account.LicenseKey),
+			Perm: credentialsFilePerm,
+		})
+	}
+
+	return files
 }
 
-func writePromConfig(prometheusConfigPath string, prometheusConfig *configurator.PromConfig) error {
+// checksumFile returns the file holding the SHA-256 checksum of the prometheus config, in the format of `sha256sum`
+// so it can be checked with `sha256sum -c` from the output directory.
+func checksumFile(prometheusConfigPath string, data []byte) watcher.File {
+	sum := sha256.Sum256(data)
+	line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), filepath.Base(prometheusConfigPath))
+
+	return watcher.File{Path: prometheusConfigPath + checksumSuffix, Data: []byte(line), Perm: prometheusConfigPerm}
+}
+
+// buildPromConfig builds the prometheus config and checks it is accepted by Prometheus before returning it marshaled.
+func buildPromConfig(nrConfig *configurator.NrConfig, opts ...configurator.BuildOption) ([]byte, error) {
+	prometheusConfig, err := configurator.BuildPromConfig(nrConfig, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the configuration: %w", err)
+	}
+
 	data, err := yaml.Marshal(prometheusConfig)
 	if err != nil {
-		return fmt.Errorf("marshaling prometheusConfig: %w", err)
+		return nil, fmt.Errorf("marshaling prometheusConfig: %w", err)
 	}
 
-	if prometheusConfigPath == "" {
-		if _, err = os.Stdout.Write(data); err != nil {
-			return fmt.Errorf("could not to stdout: %w", err)
-		}
-		return nil
+	if err := configurator.ValidatePromConfig(data); err != nil {
+		return nil, fmt.Errorf("validating prometheusConfig: %w", err)
 	}
 
-	fileWriter, err := os.Create(prometheusConfigPath)
+	return data, nil
+}
+
+// writeEffectiveConfig writes the nrConfig, already expanded by building it, with the secrets masked.
+func writeEffectiveConfig(w io.Writer, nrConfig *configurator.NrConfig) error {
+	data, err := yaml.Marshal(redact.Value(nrConfig))
 	if err != nil {
-		return fmt.Errorf("the prometheusConfig file cannot be created: %w", err)
+		return fmt.Errorf("marshaling the nrConfig: %w", err)
 	}
 
-	if _, err := fileWriter.Write(data); err != nil {
-		return fmt.Errorf("could not write the prometheusConfig: %w", err)
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("writing the nrConfig: %w", err)
 	}
 
-	if err := fileWriter.Close(); err != nil {
-		return fmt.Errorf("could not close the prometheusConfig file: %w", err)
+	return nil
+}
+
+// writePromConfig writes the prometheus config to stdout or, if a path is provided, atomically to the file so Prometheus
+// never reads a partially written config.
+func writePromConfig(prometheusConfigPath string, data []byte) error {
+	if prometheusConfigPath == "" {
+		if _, err := os.Stdout.Write(data); err != nil {
+			return fmt.Errorf("could not to stdout: %w", err)
+		}
+		return nil
+	}
+
+	if err := atomicfile.Write(prometheusConfigPath, data, prometheusConfigPerm); err != nil {
+		return fmt.Errorf("could not write the prometheusConfig: %w", err)
 	}
 
 	return nil
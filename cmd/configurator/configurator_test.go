@@ -0,0 +1,82 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/configurator"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/redact"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	baseInput = `
+newrelic_remote_write:
+  license_key: nrLicenseKey
+static_targets:
+  jobs:
+  - job_name: base
+    targets: ["base:80"]
+`
+	teamInput = `
+kubernetes:
+  jobs:
+  - job_name_prefix: team-a
+    target_discovery:
+      pod: true
+`
+)
+
+func TestSubcommandsMergeInputFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	inputDir := filepath.Join(dir, "conf.d")
+	require.NoError(t, os.Mkdir(inputDir, 0o755))
+
+	input := inputFlags{paths: stringsFlag{filepath.Join(dir, "base.yaml")}, dir: inputDir}
+	require.NoError(t, os.WriteFile(input.paths[0], []byte(baseInput), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(inputDir, "team-a.yaml"), []byte(teamInput), 0o600))
+
+	_, relabelConfig, err := loadRelabelConfig(input, false)
+	require.NoError(t, err)
+	assert.Subset(t, relabelConfig.JobNames(), []string{"base", "team-a-pod"})
+
+	assert.Equal(t, 0, runValidate([]string{"--input", input.paths[0], "--input-dir", inputDir}))
+	assert.Equal(t, 0, runExplain([]string{"--input", input.paths[0], "--input-dir", inputDir, "--job", "team-a-pod"}))
+	// The kubernetes job is only defined in the input directory.
+	assert.Equal(t, toolFailedCode, runExplain([]string{"--input", input.paths[0], "--job", "team-a-pod"}))
+}
+
+func TestRenderFilesReplacesSecrets(t *testing.T) {
+	t.Setenv(configurator.LicenseKeyEnvKey, "")
+
+	var out bytes.Buffer
+
+	logger := log.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&log.TextFormatter{DisableTimestamp: true})
+
+	secrets := redact.NewHook()
+	logger.AddHook(secrets)
+
+	input := filepath.Join(t.TempDir(), "config.yaml")
+	config := renderConfig{nrConfigPaths: []string{input}, prometheusConfigPath: "prometheus.yaml", secrets: secrets}
+
+	for _, licenseKey := range []string{"rotated-license-key", "current-license-key"} {
+		require.NoError(t, os.WriteFile(input, []byte("newrelic_remote_write:\n  license_key: "+licenseKey+"\n"), 0o600))
+
+		_, err := renderFiles(config, logger)
+		require.NoError(t, err)
+	}
+
+	logger.Info("keys rotated-license-key and current-license-key")
+	assert.Contains(t, out.String(), "keys rotated-license-key and xxxxx")
+}
@@ -0,0 +1,230 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/configurator"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/relabeling"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	explainCommand = "explain"
+
+	// offlineLicenseKey is used when no license key is available, it does not affect the relabel rules.
+	offlineLicenseKey = "offline-license-key"
+
+	// toolFailedCode is returned by the commands inspecting the config when it cannot be loaded.
+	toolFailedCode = 1
+	// toolUsageCode is returned by the commands inspecting the config when they are wrongly invoked.
+	toolUsageCode = 2
+)
+
+// runExplain implements the explain command: it runs the relabel rules generated for a job over the provided target
+// labels and prints the effect of each of them. It returns the exit code.
+func runExplain(args []string) int {
+	flags := flag.NewFlagSet(explainCommand, flag.ContinueOnError)
+
+	var input inputFlags
+	input.register(flags)
+
+	jobFlag := flags.String("job", "", "Name of the generated scrape job, like kubernetes-job-pod.")
+	labelsFlag := flags.String("labels", "", "Discovered target labels as name=value pairs separated by commas.")
+	lenient := flags.Bool("lenient", false, "Ignores unknown fields in the input.")
+
+	if err := flags.Parse(args); err != nil {
+		return toolUsageCode
+	}
+
+	if *jobFlag == "" {
+		fmt.Fprintln(os.Stderr, "The --job flag is required")
+		return toolUsageCode
+	}
+
+	discovered, err := relabeling.ParseLabels(*labelsFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing the labels: %s\n", err)
+		return toolUsageCode
+	}
+
+	nrConfig, relabelConfig, err := loadRelabelConfig(input, *lenient)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading the configuration: %s\n", err)
+		return toolFailedCode
+	}
+
+	job, err := relabelConfig.ScrapeConfig(*jobFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s, available jobs: %s\n", err, strings.Join(relabelConfig.JobNames(), ", "))
+		return toolFailedCode
+	}
+
+	if err := explain(os.Stdout, nrConfig, job, discovered); err != nil {
+		fmt.Fprintf(os.Stderr, "Error explaining the relabeling: %s\n", err)
+		return toolFailedCode
+	}
+
+	return 0
+}
+
+// loadRelabelConfig builds the prometheus config for the nrConfig merged from the input files and loads its relabel rules. The
+// returned nrConfig has the values from the environment already expanded.
+func loadRelabelConfig(input inputFlags, lenient bool) (*configurator.NrConfig, *relabeling.Config, error) {
+	nrConfig, prometheusConfigData, err := buildOfflinePromConfig(input, lenient)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	relabelConfig, err := relabeling.Load(prometheusConfigData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading relabel configs: %w", err)
+	}
+
+	return nrConfig, relabelConfig, nil
+}
+
+// offlineKey returns the offline license key for the region, prefixed by it like the license keys of the region are.
+func offlineKey(region string) string {
+	if region == "" || strings.EqualFold(region, "us") {
+		return offlineLicenseKey
+	}
+
+	return strings.ToLower(region) + "xx-" + offlineLicenseKey
+}
+
+// buildOfflinePromConfig builds the marshaled prometheus config for the nrConfig merged from the input files, to be inspected by
+// commands not requiring the license key. The returned nrConfig has the values from the environment already expanded.
+func buildOfflinePromConfig(input inputFlags, lenient bool) (*configurator.NrConfig, []byte, error) {
+	nrConfig, err := loadInput(input, lenient)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The license key file may only be mounted where the configurator runs, and the key is not needed to inspect the
+	// config anyway.
+	if nrConfig.RemoteWrite.LicenseKey == "" && os.Getenv(configurator.LicenseKeyEnvKey) == "" {
+		nrConfig.RemoteWrite.LicenseKey = offlineKey(nrConfig.RemoteWrite.Region)
+		nrConfig.RemoteWrite.LicenseKeyFile = ""
+	}
+
+	for i := range nrConfig.RemoteWrite.Accounts {
+		if account := &nrConfig.RemoteWrite.Accounts[i]; account.LicenseKey == "" {
+			region := account.Region
+			if region == "" {
+				region = nrConfig.RemoteWrite.Region
+			}
+
+			account.LicenseKey = offlineKey(region)
+			account.LicenseKeyFile = ""
+		}
+	}
+
+	// Shards get their index at runtime, the first one is inspected when it is not available.
+	if nrConfig.Sharding.ShardIndex == "" && os.Getenv(configurator.ShardIndexEnvKey) == "" &&
+		os.Getenv(configurator.DataSourceNameEnvKey) == "" {
+		nrConfig.Sharding.ShardIndex = "0"
+	}
+
+	prometheusConfig, err := configurator.BuildPromConfig(nrConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing the configuration: %w", err)
+	}
+
+	prometheusConfigData, err := yaml.Marshal(prometheusConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshaling prometheusConfig: %w", err)
+	}
+
+	return nrConfig, prometheusConfigData, nil
+}
+
+func explain(w io.Writer, nrConfig *configurator.NrConfig, job *relabeling.ScrapeConfig, discovered labels.Labels) error {
+	input := job.TargetLabels(discovered)
+	result := relabeling.Process(job.RelabelConfigs, input)
+
+	fmt.Fprintf(w, "Job: %s\n", job.JobName)
+	fmt.Fprintf(w, "Input labels: %s\n", input)
+
+	for i, step := range result.Steps {
+		fmt.Fprintf(w, "\n#%d %s\n", i+1, relabeling.FormatRule(step.Rule))
+
+		switch {
+		case !step.Kept:
+			fmt.Fprintln(w, "   dropped")
+		case step.Changed():
+			for _, change := range step.Diff() {
+				fmt.Fprintf(w, "   %s\n", change)
+			}
+		case step.Rule.Action == relabel.Keep || step.Rule.Action == relabel.KeepEqual:
+			fmt.Fprintln(w, "   kept")
+		default:
+			fmt.Fprintln(w, "   unchanged")
+		}
+	}
+
+	fmt.Fprintln(w)
+
+	if result.Kept {
+		fmt.Fprintln(w, "Result: kept")
+		fmt.Fprintf(w, "Labels after relabeling: %s\n", result.Labels)
+		fmt.Fprintf(w, "Target labels: %s\n", relabeling.ScrapedLabels(result.Labels))
+	} else {
+		fmt.Fprintf(w, "Result: dropped by rule #%d\n", result.DroppedBy())
+	}
+
+	return explainShard(w, nrConfig, job, input)
+}
+
+// explainShard prints the shard owning the target, jobs not including the sharding rules are scraped by every shard.
+func explainShard(w io.Writer, nrConfig *configurator.NrConfig, job *relabeling.ScrapeConfig, input labels.Labels) error {
+	if nrConfig.Sharding.NodeLocal() {
+		fmt.Fprintf(w, "Shard: node-local, the configuration was built for the Kubernetes targets of node %s\n", nrConfig.Sharding.NodeName)
+		return nil
+	}
+
+	if !nrConfig.Sharding.ShouldIncludeShardingRules() {
+		fmt.Fprintln(w, "Shard: sharding is disabled")
+		return nil
+	}
+
+	_, sharded, err := relabeling.SplitShardingRules(job.RelabelConfigs, nrConfig.Sharding)
+	if err != nil {
+		return err //nolint: wrapcheck
+	}
+
+	if !sharded {
+		fmt.Fprintf(w, "Shard: the job skips sharding, the target is scraped by all the %d shards\n", nrConfig.Sharding.TotalShardsCount)
+		return nil
+	}
+
+	owner, err := relabeling.ShardOwner(nrConfig.Sharding, input)
+	if errors.Is(err, relabeling.ErrNoShardOwner) {
+		fmt.Fprintf(w, "Shard: the target is not owned by any of the %d shards\n", nrConfig.Sharding.TotalShardsCount)
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("computing the shard owner: %w", err)
+	}
+
+	fmt.Fprintf(w, "Shard: owned by shard %d of %d", owner, nrConfig.Sharding.TotalShardsCount)
+
+	if nrConfig.Sharding.ShardIndex != "" {
+		fmt.Fprintf(w, ", the configuration was built for shard %s", nrConfig.Sharding.ShardIndex)
+	}
+
+	fmt.Fprintln(w)
+
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/relabeling"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/remotewrite"
+)
+
+const metricsCommand = "metrics"
+
+// runMetrics implements the metrics command: it prints, without building the whole config, the write relabel rules
+// generated for the metrics include and exclude lists and the matchers each of them covers. It returns the exit code.
+func runMetrics(args []string) int {
+	flags := flag.NewFlagSet(metricsCommand, flag.ContinueOnError)
+
+	var input inputFlags
+	input.register(flags)
+
+	lenient := flags.Bool("lenient", false, "Ignores unknown fields in the input.")
+
+	if err := flags.Parse(args); err != nil {
+		return toolUsageCode
+	}
+
+	nrConfig, err := loadInput(input, *lenient)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading the configuration: %s\n", err)
+		return toolFailedCode
+	}
+
+	rules, err := nrConfig.RemoteWrite.Metrics.Rules()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error building the metrics filter: %s\n", err)
+		return toolFailedCode
+	}
+
+	if err := reportMetricsFilter(os.Stdout, rules); err != nil {
+		fmt.Fprintf(os.Stderr, "Error building the metrics filter: %s\n", err)
+		return toolFailedCode
+	}
+
+	return 0
+}
+
+func reportMetricsFilter(w io.Writer, rules []remotewrite.FilterRule) error {
+	if len(rules) == 0 {
+		fmt.Fprintln(w, "No metrics filter, every metric is sent to New Relic")
+		return nil
+	}
+
+	promRules := make([]promcfg.RelabelConfig, 0, len(rules))
+	for _, rule := range rules {
+		promRules = append(promRules, rule.Rule)
+	}
+
+	relabelRules, err := relabeling.FromPromcfg(promRules)
+	if err != nil {
+		return fmt.Errorf("parsing the write relabel rules: %w", err)
+	}
+
+	fmt.Fprintf(w, "The metrics filter adds %d write relabel rules to the New Relic remote write:\n", len(rules))
+
+	for i, rule := range rules {
+		fmt.Fprintf(w, "\n#%d %s\n", i+1, relabeling.FormatRule(relabelRules[i]))
+
+		for _, covered := range rule.Covers {
+			fmt.Fprintf(w, "   %s\n", covered)
+		}
+	}
+
+	return nil
+}
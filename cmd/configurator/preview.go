@@ -0,0 +1,107 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/targetpreview"
+)
+
+const previewCommand = "preview"
+
+// stringsFlag is a flag which can be set several times.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// runPreview implements the preview command: it simulates the kubernetes service discovery over the provided
+// manifests and prints the targets each shard would scrape. It returns the exit code.
+func runPreview(args []string) int {
+	flags := flag.NewFlagSet(previewCommand, flag.ContinueOnError)
+
+	var input inputFlags
+	input.register(flags)
+
+	showDropped := flags.Bool("show-dropped", false, "Lists the targets dropped by relabeling and the rule dropping them.")
+	lenient := flags.Bool("lenient", false, "Ignores unknown fields in the input.")
+
+	var manifests stringsFlag
+	flags.Var(&manifests, "manifests", "File or directory holding kubernetes manifests, can be set several times.")
+
+	if err := flags.Parse(args); err != nil {
+		return toolUsageCode
+	}
+
+	if len(manifests) == 0 {
+		fmt.Fprintln(os.Stderr, "The --manifests flag is required")
+		return toolUsageCode
+	}
+
+	objects, err := targetpreview.LoadManifests(manifests...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading the manifests: %s\n", err)
+		return toolFailedCode
+	}
+
+	nrConfig, prometheusConfigData, err := buildOfflinePromConfig(input, *lenient)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading the configuration: %s\n", err)
+		return toolFailedCode
+	}
+
+	report, err := targetpreview.Preview(prometheusConfigData, nrConfig.Sharding, objects)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error previewing the targets: %s\n", err)
+		return toolFailedCode
+	}
+
+	writePreview(os.Stdout, report, objects, *showDropped)
+
+	return 0
+}
+
+func writePreview(w io.Writer, report *targetpreview.Report, objects *targetpreview.Objects, showDropped bool) {
+	kept := report.Kept()
+
+	for i, target := range kept {
+		if i == 0 || target.Shard != kept[i-1].Shard {
+			if target.Shard == targetpreview.AllShards {
+				fmt.Fprintln(w, "All shards:")
+			} else {
+				fmt.Fprintf(w, "Shard %d:\n", target.Shard)
+			}
+		}
+
+		fmt.Fprintf(w, "  %s %s (%s)\n", target.Job, target.URL, target.Source)
+		fmt.Fprintf(w, "    %s\n", target.Labels)
+	}
+
+	dropped := report.Dropped()
+
+	if showDropped && len(dropped) > 0 {
+		fmt.Fprintln(w, "Dropped:")
+
+		for _, target := range dropped {
+			fmt.Fprintf(w, "  %s %s dropped by rule #%d\n", target.Job, target.Source, target.DroppedBy)
+		}
+	}
+
+	for _, skipped := range report.Skipped {
+		fmt.Fprintf(w, "Skipped job %s: %s\n", skipped.Job, skipped.Reason)
+	}
+
+	fmt.Fprintf(w, "%d targets kept, %d dropped, %d objects of other kinds ignored\n", len(kept), len(dropped), objects.Skipped)
+}
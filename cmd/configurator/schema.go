@@ -0,0 +1,42 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/schema"
+)
+
+const schemaCommand = "schema"
+
+// runSchema implements the schema command: it prints the JSON Schema of the nrConfig. It returns the exit code.
+func runSchema(args []string) int {
+	flags := flag.NewFlagSet(schemaCommand, flag.ContinueOnError)
+	chartValues := flags.Bool("chart-values", false, "Prints the schema of the chart values instead, validating the config block.")
+
+	if err := flags.Parse(args); err != nil {
+		return toolUsageCode
+	}
+
+	s := schema.NrConfig()
+	if *chartValues {
+		s = schema.ChartValues()
+	}
+
+	data, err := schema.Marshal(s)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating the schema: %s\n", err)
+		return toolFailedCode
+	}
+
+	if _, err := os.Stdout.Write(data); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the schema: %s\n", err)
+		return toolFailedCode
+	}
+
+	return 0
+}
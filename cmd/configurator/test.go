@@ -0,0 +1,105 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/relabeltest"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+const testCommand = "test"
+
+// runTest implements the test command: it runs the test cases in the provided files over the relabel rules generated
+// for the configuration. It returns the exit code, toolFailedCode if any test fails.
+func runTest(args []string) int {
+	flags := flag.NewFlagSet(testCommand, flag.ContinueOnError)
+
+	var input inputFlags
+	input.register(flags)
+
+	lenient := flags.Bool("lenient", false, "Ignores unknown fields in the input.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [flags] test-file...\n", testCommand)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return toolUsageCode
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "At least one test file is required")
+		return toolUsageCode
+	}
+
+	nrConfig, relabelConfig, err := loadRelabelConfig(input, *lenient)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading the configuration: %s\n", err)
+		return toolFailedCode
+	}
+
+	passed, failed := 0, 0
+
+	for _, path := range flags.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading the test file: %s\n", err)
+			return toolFailedCode
+		}
+
+		suite, err := relabeltest.LoadSuite(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading %s: %s\n", path, err)
+			return toolFailedCode
+		}
+
+		for _, result := range relabeltest.Run(relabelConfig, nrConfig.Sharding, suite) {
+			if result.Passed() {
+				passed++
+				continue
+			}
+
+			failed++
+
+			writeFailure(os.Stdout, path, result)
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "%d tests passed, %d failed\n", passed, failed)
+
+	if failed > 0 {
+		return toolFailedCode
+	}
+
+	return 0
+}
+
+func writeFailure(w io.Writer, path string, result relabeltest.Result) {
+	c := result.Case
+	subject := c.Job
+	if c.Stage == relabeltest.StageWriteRelabel {
+		subject = c.RemoteWrite
+	}
+
+	fmt.Fprintf(w, "FAILED %s: %q (%s %s)\n", path, c.Name, subject, c.Stage)
+
+	switch {
+	case result.Err != nil:
+		fmt.Fprintf(w, "  error: %s\n", result.Err)
+	case c.ExpectDropped:
+		fmt.Fprintln(w, "  expected: dropped")
+		fmt.Fprintf(w, "  got:      %s\n", result.Labels)
+	case result.Dropped:
+		fmt.Fprintf(w, "  expected: %s\n", labels.FromMap(c.ExpectedLabels))
+		fmt.Fprintf(w, "  got:      dropped by rule #%d\n", result.DroppedBy)
+	default:
+		fmt.Fprintf(w, "  expected: %s\n", labels.FromMap(c.ExpectedLabels))
+		fmt.Fprintf(w, "  got:      %s\n", result.Labels)
+	}
+}
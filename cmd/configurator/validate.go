@@ -0,0 +1,79 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/configurator"
+)
+
+const (
+	validateCommand = "validate"
+
+	textFormat = "text"
+	jsonFormat = "json"
+
+	// validationFailedCode is returned by the validate command when errors are found.
+	validationFailedCode = 1
+	// validationUsageCode is returned by the validate command when it cannot run.
+	validationUsageCode = 2
+)
+
+// runValidate implements the validate command: it runs all the checks done when building the prometheus config over
+// the nrConfig merged from the input files and reports every problem found without writing any output. It returns the
+// exit code.
+func runValidate(args []string) int {
+	flags := flag.NewFlagSet(validateCommand, flag.ContinueOnError)
+
+	var input inputFlags
+	input.register(flags)
+
+	formatFlag := flags.String("format", textFormat, "Output format of the diagnostics: text or json.")
+	lenient := flags.Bool("lenient", false, "Reports unknown fields in the input as warnings instead of errors.")
+
+	if err := flags.Parse(args); err != nil {
+		return validationUsageCode
+	}
+
+	if *formatFlag != textFormat && *formatFlag != jsonFormat {
+		fmt.Fprintf(os.Stderr, "Unsupported format %q, use %q or %q\n", *formatFlag, textFormat, jsonFormat)
+		return validationUsageCode
+	}
+
+	fragments, err := readFragments(input.paths, input.dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading the nrConfig: %s\n", err)
+		return validationUsageCode
+	}
+
+	sources := make([]string, 0, len(fragments))
+	for _, fragment := range fragments {
+		sources = append(sources, fragment.Path)
+	}
+
+	source := strings.Join(sources, ",")
+
+	diags := configurator.ValidateFragments(fragments, *lenient)
+
+	if *formatFlag == jsonFormat {
+		err = diags.WriteJSON(os.Stdout, source)
+	} else {
+		err = diags.WriteText(os.Stdout, source)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the diagnostics: %s\n", err)
+		return validationUsageCode
+	}
+
+	if diags.HasErrors() {
+		return validationFailedCode
+	}
+
+	return 0
+}
@@ -0,0 +1,49 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package atomicfile writes files in a way that readers never observe a partially written content.
+package atomicfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Write writes data into a temporary file placed in the same directory as path and renames it to path once it has
+// been completely flushed to disk. Since rename is atomic within the same filesystem, readers either see the previous
+// content or the new one.
+func Write(path string, data []byte, perm os.FileMode) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+
+	tmpName := tmpFile.Name()
+	// The temporary file is removed in any error path, after a successful rename it does not exist anymore.
+	defer os.Remove(tmpName) //nolint: errcheck
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close() //nolint: errcheck,gosec
+		return fmt.Errorf("writing temporary file: %w", err)
+	}
+
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close() //nolint: errcheck,gosec
+		return fmt.Errorf("syncing temporary file: %w", err)
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("closing temporary file: %w", err)
+	}
+
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return fmt.Errorf("setting permissions to temporary file: %w", err)
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("renaming temporary file: %w", err)
+	}
+
+	return nil
+}
@@ -3,65 +3,134 @@ package configurator
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/sharding"
 )
 
 const (
 	ChartVersionEnvKey   = "NR_PROM_CHART_VERSION"
 	DataSourceNameEnvKey = "NR_PROM_DATA_SOURCE_NAME"
 	LicenseKeyEnvKey     = "NR_PROM_LICENSE_KEY"
+	NodeNameEnvKey       = "NR_PROM_NODE_NAME"
 	ProxyURLEnvKey       = "NR_PROM_PROXY_URL"
+	// ShardIndexEnvKey holds the ordinal of the replica, like the `apps.kubernetes.io/pod-index` label of StatefulSet
+	// pods exposed through the downward API.
+	ShardIndexEnvKey = "NR_PROM_SHARD_INDEX"
 )
 
 var (
 	ErrNoLicenseKeyFound = fmt.Errorf(
-		"licenseKey was not set neither in yaml config or %s environment variable", LicenseKeyEnvKey,
+		"licenseKey was not set neither in yaml config, license_key_file or %s environment variable", LicenseKeyEnvKey,
+	)
+	ErrNoNodeName = fmt.Errorf(
+		"sharding kind node requires the node name, set node_name or the %s environment variable", NodeNameEnvKey,
 	)
-	ErrInvalidShardingKind = errors.New("the only supported kind of sharding is hash")
+	ErrInvalidShardingKind  = errors.New("the supported kinds of sharding are hash, node and namespace")
+	ErrLicenseKeyFileAndKey = errors.New("license_key and license_key_file cannot be both set")
+	ErrNodeShardsCount      = errors.New("sharding kind node cannot be set along with total_shards_count")
+	ErrNamespaceSharding    = errors.New("invalid namespace sharding")
+	ErrInvalidShardIndex    = errors.New("invalid shard index")
+	ErrNodeUnrestrictedJob  = errors.New("sharding kind node only restricts the kubernetes jobs to the node")
 )
 
+// BuildOption modifies the behavior of BuildPromConfig.
+type BuildOption func(options *buildOptions)
+
+type buildOptions struct {
+	suffixDuplicateJobNames bool
+	credentialsFile         string
+}
+
+// WithSuffixedDuplicateJobNames makes BuildPromConfig rename the scrape jobs whose name is already used, appending
+// `-2`, `-3`, ... instead of failing.
+func WithSuffixedDuplicateJobNames(enabled bool) BuildOption {
+	return func(options *buildOptions) {
+		options.suffixDuplicateJobNames = enabled
+	}
+}
+
+// WithCredentialsFile makes the New Relic remote write read the license key from the file in the path, instead of
+// inlining it in the prometheus config. The caller is responsible for writing the key to that file.
+func WithCredentialsFile(path string) BuildOption {
+	return func(options *buildOptions) {
+		options.credentialsFile = path
+	}
+}
+
 // BuildPromConfig builds the prometheus config prometheusConfig from the provided nrConfig, it holds "first level" transformations
 // required to obtain a valid prometheus configuration.
-func BuildPromConfig(nrConfig *NrConfig) (*PromConfig, error) {
-	expand(nrConfig)
+func BuildPromConfig(nrConfig *NrConfig, opts ...BuildOption) (*PromConfig, error) {
+	options := &buildOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if err := expand(nrConfig); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
 
 	if err := validate(nrConfig); err != nil {
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
 
-	remoteWrite, err := nrConfig.RemoteWrite.Build()
+	remoteWriteConfig := nrConfig.RemoteWrite
+	remoteWriteConfig.CredentialsFile = options.credentialsFile
+
+	remoteWrites, err := remoteWriteConfig.BuildAll()
 	if err != nil {
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
 
 	prometheusConfig := &PromConfig{
-		RemoteWrite:  []RawPromConfig{remoteWrite},
 		GlobalConfig: nrConfig.Common,
 	}
 
+	for _, remoteWrite := range remoteWrites {
+		prometheusConfig.RemoteWrite = append(prometheusConfig.RemoteWrite, remoteWrite)
+	}
+
 	prometheusConfig.RemoteWrite = append(prometheusConfig.RemoteWrite, nrConfig.ExtraRemoteWrite...)
 
-	for _, job := range nrConfig.StaticTargets.Build(nrConfig.Sharding) {
-		prometheusConfig.ScrapeConfigs = append(prometheusConfig.ScrapeConfigs, job)
-	}
+	staticJobs := nrConfig.StaticTargets.Build(nrConfig.Sharding)
 
 	k8sJobs, err := nrConfig.Kubernetes.Build(nrConfig.Sharding)
 	if err != nil {
 		return prometheusConfig, fmt.Errorf("building k8s config: %w", err)
 	}
 
+	extraScrapeConfigs, err := buildExtraScrapeConfigs(slices.Clone(nrConfig.ExtraScrapeConfigs), nrConfig.Sharding)
+	if err != nil {
+		return prometheusConfig, fmt.Errorf("invalid config: %w", err)
+	}
+
+	if err := checkJobNames(nrConfig, staticJobs, k8sJobs, extraScrapeConfigs, options.suffixDuplicateJobNames); err != nil {
+		return prometheusConfig, fmt.Errorf("invalid config: %w", err)
+	}
+
+	for _, job := range staticJobs {
+		prometheusConfig.ScrapeConfigs = append(prometheusConfig.ScrapeConfigs, job)
+	}
+
 	for _, job := range k8sJobs {
 		prometheusConfig.ScrapeConfigs = append(prometheusConfig.ScrapeConfigs, job)
 	}
 
-	prometheusConfig.ScrapeConfigs = append(prometheusConfig.ScrapeConfigs, nrConfig.ExtraScrapeConfigs...)
+	prometheusConfig.ScrapeConfigs = append(prometheusConfig.ScrapeConfigs, extraScrapeConfigs...)
 
 	return prometheusConfig, nil
 }
 
-// expand replace some specifics configs that can be defined by env variables.
-func expand(config *NrConfig) {
+// expand replace some specifics configs that can be defined by env variables or read from files.
+func expand(config *NrConfig) error {
+	if err := readLicenseKeyFile(config); err != nil {
+		return err
+	}
+
 	if licenseKey := os.Getenv(LicenseKeyEnvKey); licenseKey != "" {
 		config.RemoteWrite.LicenseKey = licenseKey
 	}
@@ -80,31 +149,185 @@ func expand(config *NrConfig) {
 		config.RemoteWrite.DataSourceName = dataSourceName
 	}
 
-	if dataSourceName != "" && config.Sharding.TotalShardsCount > 1 {
-		shardIndex := getIndexFromDataSourceName(dataSourceName)
+	if nodeName := os.Getenv(NodeNameEnvKey); nodeName != "" {
+		config.Sharding.NodeName = nodeName
+	}
+
+	// The ordinal of the replica is preferred, parsing the data source name only works with the default pod names.
+	if shardIndex := os.Getenv(ShardIndexEnvKey); shardIndex != "" {
+		config.Sharding.ShardIndex = shardIndex
+	} else if shardIndex := getIndexFromDataSourceName(dataSourceName); shardIndex != "" && config.Sharding.TotalShardsCount > 1 {
 		config.Sharding.ShardIndex = shardIndex
 	}
+
+	return nil
+}
+
+// readLicenseKeyFile sets the license keys of the default account and the additional ones from the content of their
+// license_key_file, if any.
+func readLicenseKeyFile(config *NrConfig) error {
+	if err := readKeyFile(&config.RemoteWrite.LicenseKey, &config.RemoteWrite.LicenseKeyFile); err != nil {
+		return err
+	}
+
+	for i := range config.RemoteWrite.Accounts {
+		account := &config.RemoteWrite.Accounts[i]
+		if err := readKeyFile(&account.LicenseKey, &account.LicenseKeyFile); err != nil {
+			return fmt.Errorf("account %q: %w", account.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func readKeyFile(licenseKey *string, licenseKeyFile *string) error {
+	if *licenseKeyFile == "" {
+		return nil
+	}
+
+	if *licenseKey != "" {
+		return ErrLicenseKeyFileAndKey
+	}
+
+	data, err := os.ReadFile(*licenseKeyFile)
+	if err != nil {
+		return fmt.Errorf("reading the license_key_file: %w", err)
+	}
+
+	*licenseKey = strings.TrimSpace(string(data))
+
+	return nil
 }
 
 func validate(config *NrConfig) error {
-	if config.RemoteWrite.LicenseKey == "" {
+	// The license key of the default account is not used when it does not receive any metric.
+	if config.RemoteWrite.LicenseKey == "" && config.RemoteWrite.CatchAllEnabled() {
 		return ErrNoLicenseKeyFound
 	}
 
 	// Defaults to kind hash in case it's empty.
 	if config.Sharding.Kind == "" {
-		config.Sharding.Kind = "hash"
+		config.Sharding.Kind = sharding.KindHash
+	}
+
+	if err := validateSharding(config.Sharding); err != nil {
+		return err
+	}
+
+	if problems := nodeUnrestrictedJobs(config); len(problems) > 0 {
+		return problems[0].err
+	}
+
+	return nil
+}
+
+// jobProblem is a problem found in the job at path.
+type jobProblem struct {
+	path string
+	err  error
+}
+
+// nodeUnrestrictedJobs returns a problem for each static target job and extra scrape config not setting skip_sharding
+// when the sharding kind is node. Only the kubernetes jobs are restricted to the node, so every replica would scrape
+// them.
+func nodeUnrestrictedJobs(config *NrConfig) []jobProblem {
+	if !config.Sharding.NodeLocal() {
+		return nil
+	}
+
+	var problems []jobProblem
+
+	addProblem := func(path string, jobName any) {
+		problems = append(problems, jobProblem{path: path, err: fmt.Errorf(
+			"%w: %s %q would be scraped by every node, set skip_sharding to scrape it from all of them",
+			ErrNodeUnrestrictedJob, path, jobName,
+		)})
+	}
+
+	for i, job := range config.StaticTargets.StaticTargetJobs {
+		if !job.ScrapeJob.SkipSharding {
+			addProblem(fmt.Sprintf("%s[%d]", staticTargetJobsPath, i), job.ScrapeJob.JobName)
+		}
 	}
 
-	if config.Sharding.Kind != "hash" {
+	for i, extraScrapeConfig := range config.ExtraScrapeConfigs {
+		fields, ok := extraScrapeConfig.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		if skipSharding, _ := fields["skip_sharding"].(bool); !skipSharding {
+			addProblem(fmt.Sprintf("%s[%d]", extraScrapeConfigsPath, i), fields["job_name"])
+		}
+	}
+
+	return problems
+}
+
+func validateSharding(shardingConfig sharding.Config) error {
+	if len(shardingConfig.PinnedNamespaces) > 0 && shardingConfig.Kind != sharding.KindNamespace {
+		return fmt.Errorf("%w: pinned_namespaces require sharding kind namespace", ErrNamespaceSharding)
+	}
+
+	switch shardingConfig.Kind {
+	case "", sharding.KindHash:
+		return validateShardIndex(shardingConfig)
+	case sharding.KindNamespace:
+		if err := validateNamespaceSharding(shardingConfig); err != nil {
+			return err
+		}
+
+		return validateShardIndex(shardingConfig)
+	case sharding.KindNode:
+		if shardingConfig.NodeName == "" {
+			return ErrNoNodeName
+		}
+
+		if shardingConfig.TotalShardsCount > 1 {
+			return ErrNodeShardsCount
+		}
+
+		return nil
+	default:
 		return ErrInvalidShardingKind
 	}
+}
+
+// validateShardIndex checks the index is one of the shards, otherwise the keep rule of the sharding would silently
+// drop every target.
+func validateShardIndex(shardingConfig sharding.Config) error {
+	if !shardingConfig.ShouldIncludeShardingRules() {
+		return nil
+	}
+
+	index, err := strconv.Atoi(shardingConfig.ShardIndex)
+	if err != nil || index < 0 || index >= shardingConfig.TotalShardsCount {
+		return fmt.Errorf("%w %q: it must be an integer from 0 to %d, set shard_index or the %s environment variable",
+			ErrInvalidShardIndex, shardingConfig.ShardIndex, shardingConfig.TotalShardsCount-1, ShardIndexEnvKey)
+	}
 
 	return nil
 }
 
-// getIndexFromDataSourceName returns the corresponding shard index from the DataSourceNameEnvKey env var.
-// This function assumes the name follows the k8s name convention being `-` separated.
+func validateNamespaceSharding(shardingConfig sharding.Config) error {
+	if len(shardingConfig.HashSourceLabels) > 0 {
+		return fmt.Errorf("%w: hash_source_labels cannot be set along with sharding kind namespace", ErrNamespaceSharding)
+	}
+
+	for _, namespace := range slices.Sorted(maps.Keys(shardingConfig.PinnedNamespaces)) {
+		shard := shardingConfig.PinnedNamespaces[namespace]
+		if shard < 0 || shard >= max(shardingConfig.TotalShardsCount, 1) {
+			return fmt.Errorf("%w: namespace %q is pinned to shard %d, which is not lower than total_shards_count %d",
+				ErrNamespaceSharding, namespace, shard, shardingConfig.TotalShardsCount)
+		}
+	}
+
+	return nil
+}
+
+// getIndexFromDataSourceName returns the corresponding shard index from the DataSourceNameEnvKey env var, or empty if
+// the name does not end with an ordinal. This function assumes the name follows the k8s name convention being `-`
+// separated.
 // E.g. by running two shards the pod names will be the following:
 //
 //	1: newrelic-prometheus-agent-0
@@ -115,5 +338,10 @@ func getIndexFromDataSourceName(dataSourceName string) string {
 		return ""
 	}
 
+	// Custom names like `prom-eu-west` do not hold the ordinal.
+	if _, err := strconv.Atoi(parts[len(parts)-1]); err != nil {
+		return ""
+	}
+
 	return parts[len(parts)-1]
 }
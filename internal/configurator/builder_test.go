@@ -6,11 +6,18 @@ package configurator_test
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/newrelic/newrelic-prometheus-configurator/internal/configurator"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/kubernetes"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/relabeling"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/relabeltest"
 	"github.com/newrelic/newrelic-prometheus-configurator/internal/remotewrite"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/scrapejob"
 	"github.com/newrelic/newrelic-prometheus-configurator/internal/sharding"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/statictargets"
 	prometheusConfig "github.com/prometheus/prometheus/config"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -24,11 +31,13 @@ import (
 func TestBuilder(t *testing.T) { //nolint: tparallel
 	t.Setenv(configurator.LicenseKeyEnvKey, "")
 	t.Setenv(configurator.DataSourceNameEnvKey, "")
+	t.Setenv(configurator.ShardIndexEnvKey, "")
 
 	// it relies on testdata/<placeholder>.yaml and testdata/<placeholder>.expected.yaml
 	testCases := []string{
 		"endpoints-test",
 		"external-labels-test",
+		"extra-scrape-configs-sharding-test",
 		"filter-test",
 		"global-config-test",
 		"integration-filters-test",
@@ -55,8 +64,7 @@ func TestBuilder(t *testing.T) { //nolint: tparallel
 			require.NoError(t, err)
 			expected, err := os.ReadFile(expectedFile)
 			require.NoError(t, err)
-			nrConfig := &configurator.NrConfig{}
-			err = yaml.Unmarshal(data, nrConfig)
+			nrConfig, _, err := configurator.DecodeNrConfig(data, false)
 			require.NoError(t, err)
 			prometheusConfig, err := configurator.BuildPromConfig(nrConfig)
 			require.NoError(t, err)
@@ -69,6 +77,34 @@ func TestBuilder(t *testing.T) { //nolint: tparallel
 	}
 }
 
+func TestBuilderIsDeterministic(t *testing.T) { //nolint: paralleltest
+	t.Setenv(configurator.LicenseKeyEnvKey, "")
+	t.Setenv(configurator.DataSourceNameEnvKey, "")
+
+	// These inputs hold maps, like filters and labels, whose iteration order is random.
+	for _, name := range []string{"filter-test", "external-labels-test", "static-targets-test"} {
+		data, err := os.ReadFile("testdata/" + name + ".yaml")
+		require.NoError(t, err)
+
+		var first []byte
+
+		for range 20 {
+			nrConfig, _, err := configurator.DecodeNrConfig(data, false)
+			require.NoError(t, err)
+			prometheusConfig, err := configurator.BuildPromConfig(nrConfig)
+			require.NoError(t, err)
+			output, err := yaml.Marshal(prometheusConfig)
+			require.NoError(t, err)
+
+			if first == nil {
+				first = output
+			}
+
+			require.Equal(t, string(first), string(output), "%s rendered a different output", name)
+		}
+	}
+}
+
 func TestDataSourceName(t *testing.T) { //nolint: tparallel
 	t.Setenv(configurator.LicenseKeyEnvKey, "")
 	t.Setenv(configurator.DataSourceNameEnvKey, "")
@@ -142,6 +178,131 @@ func TestLicenseKey(t *testing.T) { //nolint: tparallel
 	})
 }
 
+func TestLicenseKeyFile(t *testing.T) { //nolint: tparallel
+	t.Setenv(configurator.LicenseKeyEnvKey, "")
+	t.Setenv(configurator.DataSourceNameEnvKey, "")
+
+	licenseKeyFile := filepath.Join(t.TempDir(), "license-key")
+	require.NoError(t, os.WriteFile(licenseKeyFile, []byte("license-key-from-file\n"), 0o600))
+
+	t.Run("IsReadFromFile", func(t *testing.T) {
+		t.Parallel()
+
+		nrConfig := &configurator.NrConfig{RemoteWrite: remotewrite.Config{LicenseKeyFile: licenseKeyFile}}
+		promConf, err := configurator.BuildPromConfig(nrConfig)
+		require.NoError(t, err)
+
+		data, _ := yaml.Marshal(promConf)
+		require.Contains(t, string(data), "credentials: license-key-from-file\n")
+	})
+
+	t.Run("FailIfMissing", func(t *testing.T) {
+		t.Parallel()
+
+		nrConfig := &configurator.NrConfig{RemoteWrite: remotewrite.Config{LicenseKeyFile: licenseKeyFile + "-missing"}}
+		_, err := configurator.BuildPromConfig(nrConfig)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("FailIfKeyIsAlsoSet", func(t *testing.T) {
+		t.Parallel()
+
+		nrConfig := &configurator.NrConfig{RemoteWrite: remotewrite.Config{LicenseKey: "fake", LicenseKeyFile: licenseKeyFile}}
+		_, err := configurator.BuildPromConfig(nrConfig)
+		require.ErrorIs(t, err, configurator.ErrLicenseKeyFileAndKey)
+	})
+
+	t.Run("IsValidatedWithoutModifyingTheConfig", func(t *testing.T) {
+		t.Parallel()
+
+		nrConfig := &configurator.NrConfig{RemoteWrite: remotewrite.Config{LicenseKeyFile: licenseKeyFile}}
+		require.Empty(t, configurator.Validate(nrConfig))
+		require.Equal(t, remotewrite.Config{LicenseKeyFile: licenseKeyFile}, nrConfig.RemoteWrite)
+	})
+}
+
+func TestCredentialsFile(t *testing.T) { //nolint: paralleltest
+	t.Setenv(configurator.LicenseKeyEnvKey, "")
+	t.Setenv(configurator.DataSourceNameEnvKey, "")
+
+	nrConfig := &configurator.NrConfig{RemoteWrite: remotewrite.Config{LicenseKey: "fake"}}
+
+	promConf, err := configurator.BuildPromConfig(nrConfig, configurator.WithCredentialsFile("/etc/prometheus/license-key"))
+	require.NoError(t, err)
+
+	data, err := yaml.Marshal(promConf)
+	require.NoError(t, err)
+	assertIsPrometheusConfig(t, data)
+	assert.Contains(t, string(data), "credentials_file: /etc/prometheus/license-key\n")
+	assert.NotContains(t, string(data), "fake")
+
+	// The nrConfig keeps the key, to be written to the credentials file.
+	assert.Equal(t, "fake", nrConfig.RemoteWrite.LicenseKey)
+}
+
+func TestAccountsRouting(t *testing.T) { //nolint: paralleltest
+	t.Setenv(configurator.LicenseKeyEnvKey, "")
+	t.Setenv(configurator.DataSourceNameEnvKey, "")
+
+	nrConfig, _, err := configurator.DecodeNrConfig([]byte(`
+newrelic_remote_write:
+  license_key: default-key
+  accounts:
+  - name: team-a
+    license_key: team-a-key
+    selector:
+      namespaces: [team-a]
+  - name: payments
+    license_key: payments-key
+    selector:
+      labels:
+        team: payments
+`), false)
+	require.NoError(t, err)
+
+	promConf, err := configurator.BuildPromConfig(nrConfig)
+	require.NoError(t, err)
+
+	data, err := yaml.Marshal(promConf)
+	require.NoError(t, err)
+	assertIsPrometheusConfig(t, data)
+
+	relabelConfig, err := relabeling.Load(data)
+	require.NoError(t, err)
+
+	suite, err := relabeltest.LoadSuite([]byte(`
+tests:
+- name: team-a metrics are not sent to the default account
+  stage: write_relabel
+  input_labels: {__name__: up, namespace: team-a}
+  expect_dropped: true
+- name: team-a metrics are sent to its account
+  stage: write_relabel
+  remote_write: newrelic_rw_team-a
+  input_labels: {__name__: up, namespace: team-a}
+  expected_labels: {__name__: up, namespace: team-a}
+- name: other metrics are not sent to team-a
+  stage: write_relabel
+  remote_write: newrelic_rw_team-a
+  input_labels: {__name__: up, namespace: team-b}
+  expect_dropped: true
+- name: payments metrics are sent to its account
+  stage: write_relabel
+  remote_write: newrelic_rw_payments
+  input_labels: {__name__: up, namespace: team-b, team: payments}
+  expected_labels: {__name__: up, namespace: team-b, team: payments}
+- name: other metrics are sent to the default account
+  stage: write_relabel
+  input_labels: {__name__: up, namespace: team-b}
+  expected_labels: {__name__: up, namespace: team-b}
+`))
+	require.NoError(t, err)
+
+	for _, result := range relabeltest.Run(relabelConfig, nrConfig.Sharding, suite) {
+		assert.True(t, result.Passed(), "%s: %v", result.Case.Name, result)
+	}
+}
+
 func TestShardingIndex(t *testing.T) {
 	t.Setenv(configurator.LicenseKeyEnvKey, "fake")
 
@@ -161,20 +322,38 @@ func TestShardingIndex(t *testing.T) {
 			},
 			expected: "1",
 			setEnv: func() {
+				t.Setenv(configurator.ShardIndexEnvKey, "")
 				t.Setenv(configurator.DataSourceNameEnvKey, "newrelic-prometheus-1")
 			},
 		},
 		{
-			name: "IsSetToEmptyWhenInvalidEnvVaR",
+			name: "IsSetFromShardIndexEnvVar",
+			config: configurator.NrConfig{
+				Sharding: sharding.Config{
+					Kind:             "hash",
+					TotalShardsCount: 3,
+					ShardIndex:       "0",
+				},
+			},
+			expected: "2",
+			setEnv: func() {
+				t.Setenv(configurator.ShardIndexEnvKey, "2")
+				t.Setenv(configurator.DataSourceNameEnvKey, "newrelic-prometheus-1")
+			},
+		},
+		{
+			name: "HonoursConfigWhenNameHasNoOrdinal",
 			config: configurator.NrConfig{
 				Sharding: sharding.Config{
 					Kind:             "hash",
 					TotalShardsCount: 2,
+					ShardIndex:       "1",
 				},
 			},
-			expected: "",
+			expected: "1",
 			setEnv: func() {
-				t.Setenv(configurator.DataSourceNameEnvKey, "invalid_name")
+				t.Setenv(configurator.ShardIndexEnvKey, "")
+				t.Setenv(configurator.DataSourceNameEnvKey, "prom-eu-west")
 			},
 		},
 		{
@@ -182,12 +361,13 @@ func TestShardingIndex(t *testing.T) {
 			config: configurator.NrConfig{
 				Sharding: sharding.Config{
 					Kind:             "hash",
-					TotalShardsCount: 2,
+					TotalShardsCount: 4,
 					ShardIndex:       "3",
 				},
 			},
 			expected: "3",
 			setEnv: func() {
+				t.Setenv(configurator.ShardIndexEnvKey, "")
 				t.Setenv(configurator.DataSourceNameEnvKey, "")
 			},
 		},
@@ -205,6 +385,249 @@ func TestShardingIndex(t *testing.T) {
 	}
 }
 
+func TestShardingIndexErrors(t *testing.T) {
+	t.Setenv(configurator.LicenseKeyEnvKey, "fake")
+
+	testCases := []struct {
+		name           string
+		shardIndex     string
+		shardIndexEnv  string
+		dataSourceName string
+	}{
+		{name: "NameHasNoOrdinal", dataSourceName: "prom-eu-west"},
+		{name: "InvalidName", dataSourceName: "invalid_name"},
+		{name: "NotAnInteger", shardIndex: "west"},
+		{name: "Negative", shardIndex: "-1"},
+		{name: "NotLowerThanTotal", shardIndex: "2"},
+		{name: "EnvVarNotLowerThanTotal", shardIndex: "0", shardIndexEnv: "5"},
+		{name: "NameOrdinalNotLowerThanTotal", dataSourceName: "newrelic-prometheus-7"},
+	}
+
+	for _, c := range testCases { //nolint: paralleltest
+		t.Run(c.name, func(t *testing.T) {
+			t.Setenv(configurator.ShardIndexEnvKey, c.shardIndexEnv)
+			t.Setenv(configurator.DataSourceNameEnvKey, c.dataSourceName)
+
+			nrConfig := &configurator.NrConfig{
+				Sharding: sharding.Config{TotalShardsCount: 2, ShardIndex: c.shardIndex},
+			}
+
+			_, err := configurator.BuildPromConfig(nrConfig)
+			require.ErrorIs(t, err, configurator.ErrInvalidShardIndex)
+		})
+	}
+}
+
+func TestNodeSharding(t *testing.T) {
+	t.Setenv(configurator.LicenseKeyEnvKey, "fake")
+	t.Setenv(configurator.DataSourceNameEnvKey, "newrelic-prometheus-agent-abcde")
+	t.Setenv(configurator.NodeNameEnvKey, "node-1")
+
+	nrConfig := &configurator.NrConfig{
+		Sharding: sharding.Config{Kind: sharding.KindNode},
+		Kubernetes: kubernetes.Config{K8sJobs: []kubernetes.K8sJob{{
+			JobNamePrefix:   "default",
+			TargetDiscovery: kubernetes.TargetDiscovery{Pod: true, Endpoints: true},
+		}}},
+	}
+
+	promConfig, err := configurator.BuildPromConfig(nrConfig)
+	require.NoError(t, err)
+	require.Len(t, promConfig.ScrapeConfigs, 2)
+
+	pods, ok := promConfig.ScrapeConfigs[0].(promcfg.Job)
+	require.True(t, ok)
+	assert.Equal(t, &[]promcfg.KubernetesSdSelector{{Role: "pod", Field: "spec.nodeName=node-1"}}, pods.KubernetesSdConfigs[0].Selectors)
+	assert.NotEqual(t, "__tmp_hash", pods.RelabelConfigs[0].TargetLabel)
+
+	endpoints, ok := promConfig.ScrapeConfigs[1].(promcfg.Job)
+	require.True(t, ok)
+	assert.Nil(t, endpoints.KubernetesSdConfigs[0].Selectors)
+	assert.Equal(t, promcfg.RelabelConfig{
+		SourceLabels: []string{"__meta_kubernetes_pod_node_name"},
+		Regex:        "node-1",
+		Action:       "keep",
+	}, endpoints.RelabelConfigs[0])
+}
+
+func TestNodeShardingErrors(t *testing.T) {
+	t.Setenv(configurator.LicenseKeyEnvKey, "fake")
+	t.Setenv(configurator.NodeNameEnvKey, "")
+
+	_, err := configurator.BuildPromConfig(&configurator.NrConfig{Sharding: sharding.Config{Kind: sharding.KindNode}})
+	require.ErrorIs(t, err, configurator.ErrNoNodeName)
+
+	_, err = configurator.BuildPromConfig(&configurator.NrConfig{
+		Sharding: sharding.Config{Kind: sharding.KindNode, NodeName: "node-1", TotalShardsCount: 2},
+	})
+	require.ErrorIs(t, err, configurator.ErrNodeShardsCount)
+}
+
+func TestNodeShardingUnrestrictedJobs(t *testing.T) {
+	t.Setenv(configurator.LicenseKeyEnvKey, "fake")
+	t.Setenv(configurator.NodeNameEnvKey, "node-1")
+
+	staticJob := func(name string, skipSharding bool) statictargets.StaticTargetJob {
+		return statictargets.StaticTargetJob{
+			ScrapeJob: scrapejob.Job{Job: promcfg.Job{JobName: name}, SkipSharding: skipSharding},
+			Targets:   []string{"localhost:9090"},
+		}
+	}
+
+	cases := []struct {
+		name               string
+		staticJobs         []statictargets.StaticTargetJob
+		extraScrapeConfigs []configurator.RawPromConfig
+		expectedMessage    string
+	}{
+		{
+			name:       "jobs skipping sharding",
+			staticJobs: []statictargets.StaticTargetJob{staticJob("self-metrics", true)},
+			extraScrapeConfigs: []configurator.RawPromConfig{
+				map[string]any{"job_name": "federation", "skip_sharding": true},
+			},
+		},
+		{
+			name:       "static target job",
+			staticJobs: []statictargets.StaticTargetJob{staticJob("self-metrics", true), staticJob("database", false)},
+			expectedMessage: `invalid config: sharding kind node only restricts the kubernetes jobs to the node: ` +
+				`static_targets.jobs[1] "database" would be scraped by every node, set skip_sharding to scrape it from all of them`,
+		},
+		{
+			name:               "extra scrape config",
+			extraScrapeConfigs: []configurator.RawPromConfig{map[string]any{"job_name": "federation"}},
+			expectedMessage: `invalid config: sharding kind node only restricts the kubernetes jobs to the node: ` +
+				`extra_scrape_configs[0] "federation" would be scraped by every node, set skip_sharding to scrape it from all of them`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			nrConfig := &configurator.NrConfig{
+				Sharding:           sharding.Config{Kind: sharding.KindNode},
+				StaticTargets:      statictargets.Config{StaticTargetJobs: c.staticJobs},
+				ExtraScrapeConfigs: c.extraScrapeConfigs,
+			}
+
+			promConfig, err := configurator.BuildPromConfig(nrConfig)
+			if c.expectedMessage == "" {
+				require.NoError(t, err)
+				assert.Len(t, promConfig.ScrapeConfigs, len(c.staticJobs)+len(c.extraScrapeConfigs))
+
+				return
+			}
+
+			require.ErrorIs(t, err, configurator.ErrNodeUnrestrictedJob)
+			assert.EqualError(t, err, c.expectedMessage)
+		})
+	}
+}
+
+func TestNamespaceShardingErrors(t *testing.T) {
+	t.Setenv(configurator.LicenseKeyEnvKey, "fake")
+
+	cases := []struct {
+		name            string
+		sharding        sharding.Config
+		expectedMessage string
+	}{
+		{
+			name:            "pins without namespace kind",
+			sharding:        sharding.Config{TotalShardsCount: 2, PinnedNamespaces: map[string]int{"tenant-a": 1}},
+			expectedMessage: "invalid config: invalid namespace sharding: pinned_namespaces require sharding kind namespace",
+		},
+		{
+			name: "pin out of range",
+			sharding: sharding.Config{
+				Kind:             sharding.KindNamespace,
+				TotalShardsCount: 2,
+				PinnedNamespaces: map[string]int{"tenant-a": 2},
+			},
+			expectedMessage: `invalid config: invalid namespace sharding: namespace "tenant-a" is pinned to shard 2, ` +
+				"which is not lower than total_shards_count 2",
+		},
+		{
+			name:            "hash source labels",
+			sharding:        sharding.Config{Kind: sharding.KindNamespace, TotalShardsCount: 2, HashSourceLabels: []string{"job"}},
+			expectedMessage: "invalid config: invalid namespace sharding: hash_source_labels cannot be set along with sharding kind namespace",
+		},
+	}
+
+	for _, c := range cases { //nolint: paralleltest
+		t.Run(c.name, func(t *testing.T) {
+			_, err := configurator.BuildPromConfig(&configurator.NrConfig{Sharding: c.sharding})
+			require.ErrorIs(t, err, configurator.ErrNamespaceSharding)
+			require.EqualError(t, err, c.expectedMessage)
+		})
+	}
+}
+
+func TestExtraScrapeConfigsSharding(t *testing.T) {
+	t.Setenv(configurator.LicenseKeyEnvKey, "fake")
+	t.Setenv(configurator.ShardIndexEnvKey, "")
+	t.Setenv(configurator.DataSourceNameEnvKey, "")
+
+	extraScrapeConfig := map[string]any{
+		"job_name":       "extra",
+		"static_configs": []any{map[string]any{"targets": []any{"192.168.3.1:9100"}}},
+	}
+
+	nrConfig := &configurator.NrConfig{
+		Sharding:           sharding.Config{TotalShardsCount: 2, ShardIndex: "0"},
+		ExtraScrapeConfigs: []configurator.RawPromConfig{extraScrapeConfig},
+	}
+
+	promConfig, err := configurator.BuildPromConfig(nrConfig)
+	require.NoError(t, err)
+	assert.Equal(t, []configurator.RawPromConfig{extraScrapeConfig}, promConfig.ScrapeConfigs,
+		"extra scrape configs are not sharded unless enabled")
+
+	nrConfig.Sharding.IncludeExtraScrapeConfigs = true
+
+	promConfig, err = configurator.BuildPromConfig(nrConfig)
+	require.NoError(t, err)
+	require.Len(t, promConfig.ScrapeConfigs, 1)
+
+	sharded, ok := promConfig.ScrapeConfigs[0].(map[string]any)
+	require.True(t, ok)
+	expectedRules := []any{}
+	for _, rule := range nrConfig.Sharding.RelabelConfigs() {
+		expectedRules = append(expectedRules, rule)
+	}
+
+	assert.Equal(t, expectedRules, sharded["relabel_configs"])
+	assert.Equal(t, extraScrapeConfig["static_configs"], sharded["static_configs"])
+	assert.NotContains(t, extraScrapeConfig, "relabel_configs", "the nrConfig is not modified")
+}
+
+func TestExtraScrapeConfigsShardingErrors(t *testing.T) {
+	t.Setenv(configurator.LicenseKeyEnvKey, "fake")
+	t.Setenv(configurator.ShardIndexEnvKey, "")
+	t.Setenv(configurator.DataSourceNameEnvKey, "")
+
+	cases := []struct {
+		name              string
+		extraScrapeConfig configurator.RawPromConfig
+	}{
+		{name: "not a mapping", extraScrapeConfig: "extra"},
+		{name: "invalid relabel configs", extraScrapeConfig: map[string]any{"job_name": "extra", "relabel_configs": "drop"}},
+		{name: "invalid skip sharding", extraScrapeConfig: map[string]any{"job_name": "extra", "skip_sharding": "maybe"}},
+	}
+
+	for _, c := range cases { //nolint: paralleltest
+		t.Run(c.name, func(t *testing.T) {
+			nrConfig := &configurator.NrConfig{
+				Sharding:           sharding.Config{TotalShardsCount: 2, ShardIndex: "0", IncludeExtraScrapeConfigs: true},
+				ExtraScrapeConfigs: []configurator.RawPromConfig{c.extraScrapeConfig},
+			}
+
+			_, err := configurator.BuildPromConfig(nrConfig)
+			require.ErrorIs(t, err, configurator.ErrExtraScrapeConfig)
+			assert.Contains(t, err.Error(), "extra_scrape_configs[0]")
+		})
+	}
+}
+
 func TestChartVersion(t *testing.T) {
 	t.Setenv(configurator.LicenseKeyEnvKey, "fake")
 
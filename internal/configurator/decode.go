@@ -0,0 +1,208 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package configurator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/yamlfields"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrUnknownFields = errors.New("unknown fields in nrConfig")
+
+// unknownFieldRegex matches the errors reported by the yaml library when decoding with known fields enabled.
+var unknownFieldRegex = regexp.MustCompile(`^line (\d+): field (.+) not found in type (\S+)$`)
+
+// knownFieldsByType holds the yaml keys supported by each of the types reachable from NrConfig.
+//
+//nolint:gochecknoglobals
+var knownFieldsByType = sync.OnceValue(func() map[string][]string {
+	known := map[string][]string{}
+	collectKnownFields(reflect.TypeFor[NrConfig](), known)
+
+	return known
+})
+
+// UnknownField is a key in the nrConfig which does not match any supported field.
+type UnknownField struct {
+	// File is set when the field was found in one of several fragments.
+	File  string
+	Line  int
+	Field string
+	// Type is the Go type where the field was expected, like `scrapejob.Job`.
+	Type string
+	// Suggestion holds the closest supported field, if any is similar enough.
+	Suggestion string
+}
+
+// String returns a human-readable description of the unknown field including its line.
+func (f UnknownField) String() string {
+	if f.File != "" {
+		return fmt.Sprintf("%s: line %d: %s", f.File, f.Line, f.Description())
+	}
+
+	return fmt.Sprintf("line %d: %s", f.Line, f.Description())
+}
+
+// Description returns a human-readable description of the unknown field.
+func (f UnknownField) Description() string {
+	msg := fmt.Sprintf("unknown field %q in %s", f.Field, f.Type)
+	if f.Suggestion != "" {
+		msg += fmt.Sprintf(", did you mean %q?", f.Suggestion)
+	}
+
+	return msg
+}
+
+// DecodeNrConfig decodes the nrConfig yaml rejecting unknown fields, so typos in the config are not silently
+// ignored. When lenient is true, unknown fields do not fail the decoding, they are just returned to be reported.
+func DecodeNrConfig(data []byte, lenient bool) (*NrConfig, []UnknownField, error) {
+	nrConfig := &NrConfig{}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err := decoder.Decode(nrConfig)
+	if errors.Is(err, io.EOF) {
+		// Empty input is decoded as an empty config, as yaml.Unmarshal does.
+		return nrConfig, nil, nil
+	}
+
+	unknownFields, err := splitUnknownFields(err)
+	if err != nil {
+		return nil, unknownFields, fmt.Errorf("yaml nrConfig could not be loaded: %w", err)
+	}
+
+	if len(unknownFields) > 0 && !lenient {
+		msgs := make([]string, 0, len(unknownFields))
+		for _, f := range unknownFields {
+			msgs = append(msgs, f.String())
+		}
+
+		return nil, unknownFields, fmt.Errorf("%w: %s", ErrUnknownFields, strings.Join(msgs, "; "))
+	}
+
+	return nrConfig, unknownFields, nil
+}
+
+// splitUnknownFields extracts the unknown field errors from the decoding error. Any other decoding problem is
+// returned as error.
+func splitUnknownFields(err error) ([]UnknownField, error) {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return nil, err
+	}
+
+	var unknownFields []UnknownField
+
+	var otherErrors []string
+
+	for _, msg := range typeErr.Errors {
+		matches := unknownFieldRegex.FindStringSubmatch(msg)
+		if matches == nil {
+			otherErrors = append(otherErrors, msg)
+			continue
+		}
+
+		line, _ := strconv.Atoi(matches[1])
+		unknownFields = append(unknownFields, UnknownField{
+			Line:       line,
+			Field:      matches[2],
+			Type:       matches[3],
+			Suggestion: suggestField(matches[2], matches[3]),
+		})
+	}
+
+	if len(otherErrors) > 0 {
+		return unknownFields, &yaml.TypeError{Errors: otherErrors}
+	}
+
+	return unknownFields, nil
+}
+
+// suggestField returns the known field of the type closest to the unknown one, or empty if none is close enough.
+func suggestField(field string, typeName string) string {
+	candidates, ok := knownFieldsByType()[typeName]
+	if !ok {
+		return ""
+	}
+
+	// Allow roughly one edit every three characters, which covers typical typos and singular/plural mistakes.
+	maxDistance := max(2, len(field)/3) //nolint: mnd
+
+	suggestion := ""
+	bestDistance := maxDistance + 1
+
+	for _, candidate := range candidates {
+		if d := levenshtein(field, candidate); d < bestDistance {
+			suggestion, bestDistance = candidate, d
+		}
+	}
+
+	return suggestion
+}
+
+// collectKnownFields walks the type adding the yaml keys of every struct found, fields inlined are added to the
+// struct including them since that is where the yaml library expects them.
+func collectKnownFields(t reflect.Type, known map[string][]string) {
+	t = yamlfields.Indirect(t)
+
+	switch t.Kind() { //nolint: exhaustive
+	case reflect.Slice, reflect.Map:
+		collectKnownFields(t.Elem(), known)
+	case reflect.Struct:
+		if _, visited := known[t.String()]; visited {
+			return
+		}
+
+		fields := yamlfields.Of(t)
+
+		names := make([]string, 0, len(fields))
+		for _, field := range fields {
+			names = append(names, field.Name)
+		}
+
+		known[t.String()] = names
+
+		for _, field := range fields {
+			collectKnownFields(field.Type, known)
+		}
+	}
+}
+
+// levenshtein returns the edit distance between two strings.
+func levenshtein(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
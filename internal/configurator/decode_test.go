@@ -0,0 +1,80 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package configurator_test
+
+import (
+	"testing"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/configurator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const misspelledConfig = `
+newrelic_remote_write:
+  license_key: fake
+  licence_key: other
+static_targets:
+  jobs:
+  - job_name: static
+    targets: ["localhost:9090"]
+    extra_relabel_configs:
+    - action: drop
+kubernetes:
+  integration_filter:
+    enabled: false
+  jobs:
+  - job_name_prefix: pods
+    target_discovery:
+      pod: true
+      filters:
+        annotations:
+          prometheus.io/scrape: "true"
+  - job_name_prefix: completely-unknown
+    target_discovery:
+      pod: true
+    something_else: true
+`
+
+func TestDecodeNrConfigRejectsUnknownFields(t *testing.T) {
+	t.Parallel()
+
+	_, unknownFields, err := configurator.DecodeNrConfig([]byte(misspelledConfig), false)
+	require.ErrorIs(t, err, configurator.ErrUnknownFields)
+
+	expected := []configurator.UnknownField{
+		{Line: 4, Field: "licence_key", Type: "remotewrite.Config", Suggestion: "license_key"},
+		{Line: 9, Field: "extra_relabel_configs", Type: "statictargets.StaticTargetJob", Suggestion: "extra_relabel_config"},
+		{Line: 12, Field: "integration_filter", Type: "kubernetes.Config", Suggestion: "integrations_filter"},
+		{Line: 18, Field: "filters", Type: "kubernetes.TargetDiscovery", Suggestion: "filter"},
+		{Line: 24, Field: "something_else", Type: "kubernetes.K8sJob"},
+	}
+	assert.Equal(t, expected, unknownFields)
+	assert.Contains(t, err.Error(), `line 9: unknown field "extra_relabel_configs" in statictargets.StaticTargetJob, did you mean "extra_relabel_config"?`)
+}
+
+func TestDecodeNrConfigLenient(t *testing.T) {
+	t.Parallel()
+
+	nrConfig, unknownFields, err := configurator.DecodeNrConfig([]byte(misspelledConfig), true)
+	require.NoError(t, err)
+	assert.Len(t, unknownFields, 5)
+	// Known fields are still decoded.
+	assert.Equal(t, "fake", nrConfig.RemoteWrite.LicenseKey)
+	require.Len(t, nrConfig.Kubernetes.K8sJobs, 2)
+	assert.True(t, nrConfig.Kubernetes.K8sJobs[0].TargetDiscovery.Pod)
+}
+
+func TestDecodeNrConfigKeepsOtherErrors(t *testing.T) {
+	t.Parallel()
+
+	_, _, err := configurator.DecodeNrConfig([]byte("common:\n  scrape_interval: never\n"), true)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, configurator.ErrUnknownFields)
+
+	nrConfig, unknownFields, err := configurator.DecodeNrConfig([]byte(""), false)
+	require.NoError(t, err)
+	assert.Empty(t, unknownFields)
+	assert.Equal(t, &configurator.NrConfig{}, nrConfig)
+}
@@ -0,0 +1,120 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package configurator
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/scrapejob"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/sharding"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrExtraScrapeConfig = errors.New("invalid extra scrape config")
+
+// scrapeJobKeys are the keys of an extra scrape config read as a `scrapejob.Job`, they are not known by prometheus so
+// they are removed from the built config.
+//
+//nolint:gochecknoglobals
+var scrapeJobKeys = []string{"skip_sharding", "extra_relabel_config", "extra_metric_relabel_config"}
+
+// buildExtraScrapeConfigs returns the extra scrape configs including the sharding rules, unless the job sets
+// `skip_sharding`, when the sharding is enabled for them. Otherwise they are returned as they are.
+func buildExtraScrapeConfigs(extraScrapeConfigs []RawPromConfig, shardingConfig sharding.Config) ([]RawPromConfig, error) {
+	if !shardingConfig.IncludeExtraScrapeConfigs {
+		return extraScrapeConfigs, nil
+	}
+
+	built := make([]RawPromConfig, 0, len(extraScrapeConfigs))
+
+	for i, extraScrapeConfig := range extraScrapeConfigs {
+		job, err := buildExtraScrapeConfig(extraScrapeConfig, shardingConfig)
+		if err != nil {
+			return nil, fmt.Errorf("%w extra_scrape_configs[%d]: %w", ErrExtraScrapeConfig, i, err)
+		}
+
+		built = append(built, job)
+	}
+
+	return built, nil
+}
+
+// buildExtraScrapeConfig adds the sharding rules before the relabel configs of the extra scrape config and the extra
+// ones after them, as `scrapejob.Job.BuildPrometheusJob` does. The relabel configs of the extra scrape config are kept
+// as they are, so values like an empty replacement are not lost. The returned config is a copy so the one in the
+// nrConfig is not modified.
+func buildExtraScrapeConfig(extraScrapeConfig RawPromConfig, shardingConfig sharding.Config) (RawPromConfig, error) {
+	fields, ok := extraScrapeConfig.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected a mapping, got %T", extraScrapeConfig)
+	}
+
+	jobFields := map[string]any{}
+
+	for _, key := range scrapeJobKeys {
+		if value, ok := fields[key]; ok {
+			jobFields[key] = value
+		}
+	}
+
+	data, err := yaml.Marshal(jobFields)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling job fields: %w", err)
+	}
+
+	job := scrapejob.Job{}
+	if err := yaml.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("reading job fields: %w", err)
+	}
+
+	fields = maps.Clone(fields)
+	for _, key := range scrapeJobKeys {
+		delete(fields, key)
+	}
+
+	if err := wrapRelabelConfigs(fields, "relabel_configs", job.ShardingRules(shardingConfig), job.ExtraRelabelConfigs); err != nil {
+		return nil, err
+	}
+
+	if err := wrapRelabelConfigs(fields, "metric_relabel_configs", nil, job.ExtraMetricRelabelConfigs); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// wrapRelabelConfigs sets in the key of the fields the relabel configs holding the prefix, the raw ones already in the
+// key and the suffix. The fields are not modified if there is nothing to add.
+func wrapRelabelConfigs(fields map[string]any, key string, prefix, suffix []promcfg.RelabelConfig) error {
+	if len(prefix) == 0 && len(suffix) == 0 {
+		return nil
+	}
+
+	var raw []any
+
+	if value, ok := fields[key]; ok && value != nil {
+		if raw, ok = value.([]any); !ok {
+			return fmt.Errorf("%s: expected a sequence, got %T", key, value)
+		}
+	}
+
+	relabelConfigs := make([]any, 0, len(prefix)+len(raw)+len(suffix))
+
+	for _, rule := range prefix {
+		relabelConfigs = append(relabelConfigs, rule)
+	}
+
+	relabelConfigs = append(relabelConfigs, raw...)
+
+	for _, rule := range suffix {
+		relabelConfigs = append(relabelConfigs, rule)
+	}
+
+	fields[key] = relabelConfigs
+
+	return nil
+}
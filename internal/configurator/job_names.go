@@ -0,0 +1,172 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package configurator
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+)
+
+const jobNameKey = "job_name"
+
+var ErrDuplicateJobName = errors.New("duplicate scrape job name")
+
+// JobOrigin identifies the nrConfig entry generating a scrape job.
+type JobOrigin struct {
+	// Path is the path of the entry, like `kubernetes.jobs[1]`.
+	Path string
+	// Kind is set for kubernetes jobs, which generate a job for each kind of target.
+	Kind string
+	// Source is set when the nrConfig was loaded from fragments.
+	Source Source
+}
+
+// String returns a human-readable description of the origin, like `kubernetes.jobs[1] pod job (team.yaml:12)`.
+func (o JobOrigin) String() string {
+	s := o.Path
+	if o.Kind != "" {
+		s += " " + o.Kind + " job"
+	}
+
+	if o.Source.File != "" {
+		s += " (" + o.Source.String() + ")"
+	}
+
+	return s
+}
+
+// DuplicateJobNameError is returned when two scrape jobs end up with the same name, which Prometheus rejects.
+type DuplicateJobNameError struct {
+	Name   string
+	First  JobOrigin
+	Second JobOrigin
+}
+
+func (e *DuplicateJobNameError) Error() string {
+	return fmt.Sprintf("%s %q: generated by %s and %s", ErrDuplicateJobName, e.Name, e.First, e.Second)
+}
+
+func (e *DuplicateJobNameError) Unwrap() error {
+	return ErrDuplicateJobName
+}
+
+// generatedJob is a scrape job being built along with the entry generating it.
+type generatedJob struct {
+	origin JobOrigin
+	name   string
+	rename func(name string)
+}
+
+// checkJobNames fails if two scrape jobs have the same name. When suffix is set, the repeated names are suffixed
+// instead with the first free `-<n>`, starting by `-2`, following the order of the jobs in the prometheus config.
+// The extra scrape configs renamed are replaced by a copy, so the ones in the nrConfig are not modified.
+func checkJobNames(
+	nrConfig *NrConfig,
+	staticJobs []promcfg.Job,
+	k8sJobs []promcfg.Job,
+	extraScrapeConfigs []RawPromConfig,
+	suffix bool,
+) error {
+	jobs := generatedJobs(nrConfig, staticJobs, k8sJobs, extraScrapeConfigs)
+
+	used := map[string]bool{}
+	for _, job := range jobs {
+		used[job.name] = true
+	}
+
+	firstOrigin := map[string]JobOrigin{}
+
+	for _, job := range jobs {
+		first, duplicated := firstOrigin[job.name]
+		if !duplicated {
+			firstOrigin[job.name] = job.origin
+			continue
+		}
+
+		if !suffix {
+			return &DuplicateJobNameError{Name: job.name, First: first, Second: job.origin}
+		}
+
+		name := job.name
+		for n := 2; ; n++ {
+			name = fmt.Sprintf("%s-%d", job.name, n)
+			if !used[name] {
+				break
+			}
+		}
+
+		used[name] = true
+		firstOrigin[name] = job.origin
+		job.rename(name)
+	}
+
+	return nil
+}
+
+// generatedJobs returns the scrape jobs in the order they are added to the prometheus config. Extra scrape configs
+// without a name are skipped, Prometheus rejects them anyway.
+func generatedJobs(
+	nrConfig *NrConfig,
+	staticJobs []promcfg.Job,
+	k8sJobs []promcfg.Job,
+	extraScrapeConfigs []RawPromConfig,
+) []generatedJob {
+	jobs := make([]generatedJob, 0, len(staticJobs)+len(k8sJobs)+len(extraScrapeConfigs))
+
+	for i := range staticJobs {
+		jobs = append(jobs, generatedJob{
+			origin: nrConfig.jobOrigin(fmt.Sprintf("%s[%d]", staticTargetJobsPath, i), ""),
+			name:   staticJobs[i].JobName,
+			rename: func(name string) { staticJobs[i].JobName = name },
+		})
+	}
+
+	// Kubernetes jobs generate a job for the pods and another for the endpoints, in that order.
+	k8sIndex := 0
+
+	for i, k8sJob := range nrConfig.Kubernetes.K8sJobs {
+		for _, kind := range []struct {
+			name    string
+			enabled bool
+		}{{"pod", k8sJob.TargetDiscovery.Pod}, {"endpoints", k8sJob.TargetDiscovery.Endpoints}} {
+			if !kind.enabled || k8sIndex >= len(k8sJobs) {
+				continue
+			}
+
+			job := &k8sJobs[k8sIndex]
+			jobs = append(jobs, generatedJob{
+				origin: nrConfig.jobOrigin(fmt.Sprintf("%s[%d]", kubernetesJobsPath, i), kind.name),
+				name:   job.JobName,
+				rename: func(name string) { job.JobName = name },
+			})
+			k8sIndex++
+		}
+	}
+
+	for i, extra := range extraScrapeConfigs {
+		fields, ok := extra.(map[string]any)
+		if !ok || stringValue(fields[jobNameKey]) == "" {
+			continue
+		}
+
+		jobs = append(jobs, generatedJob{
+			origin: nrConfig.jobOrigin(fmt.Sprintf("%s[%d]", extraScrapeConfigsPath, i), ""),
+			name:   stringValue(fields[jobNameKey]),
+			rename: func(name string) {
+				renamed := maps.Clone(fields)
+				renamed[jobNameKey] = name
+				extraScrapeConfigs[i] = renamed
+			},
+		})
+	}
+
+	return jobs
+}
+
+func (c *NrConfig) jobOrigin(path string, kind string) JobOrigin {
+	return JobOrigin{Path: path, Kind: kind, Source: c.Sources[path]}
+}
@@ -0,0 +1,151 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package configurator_test
+
+import (
+	"testing"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/configurator"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/diagnostic"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const duplicateJobsConfig = `
+newrelic_remote_write:
+  license_key: nrLicenseKey
+static_targets:
+  jobs:
+  - job_name: team-pod
+    targets: ["a:80"]
+kubernetes:
+  jobs:
+  - job_name_prefix: team
+    target_discovery:
+      pod: true
+      endpoints: true
+extra_scrape_configs:
+- job_name: team-endpoints
+  static_configs:
+  - targets: ["b:80"]
+- job_name: team-pod-2
+  static_configs:
+  - targets: ["c:80"]
+`
+
+func TestDuplicateJobNames(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		config string
+		first  configurator.JobOrigin
+		second configurator.JobOrigin
+	}{
+		{
+			name:   "StaticAndKubernetes",
+			config: duplicateJobsConfig,
+			first:  configurator.JobOrigin{Path: "static_targets.jobs[0]"},
+			second: configurator.JobOrigin{Path: "kubernetes.jobs[0]", Kind: "pod"},
+		},
+		{
+			name: "KubernetesPrefixes",
+			config: `
+kubernetes:
+  jobs:
+  - job_name_prefix: team
+    target_discovery:
+      endpoints: true
+  - job_name_prefix: team
+    target_discovery:
+      pod: true
+      endpoints: true
+`,
+			first:  configurator.JobOrigin{Path: "kubernetes.jobs[0]", Kind: "endpoints"},
+			second: configurator.JobOrigin{Path: "kubernetes.jobs[1]", Kind: "endpoints"},
+		},
+		{
+			name: "ExtraScrapeConfigs",
+			config: `
+static_targets:
+  jobs:
+  - job_name: node
+    targets: ["a:80"]
+extra_scrape_configs:
+- job_name: node
+`,
+			first:  configurator.JobOrigin{Path: "static_targets.jobs[0]"},
+			second: configurator.JobOrigin{Path: "extra_scrape_configs[0]"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			nrConfig, _, err := configurator.DecodeNrConfig([]byte(tc.config), false)
+			require.NoError(t, err)
+			nrConfig.RemoteWrite.LicenseKey = "nrLicenseKey"
+
+			_, err = configurator.BuildPromConfig(nrConfig)
+			require.ErrorIs(t, err, configurator.ErrDuplicateJobName)
+
+			var duplicateErr *configurator.DuplicateJobNameError
+			require.ErrorAs(t, err, &duplicateErr)
+			assert.Equal(t, tc.first, duplicateErr.First)
+			assert.Equal(t, tc.second, duplicateErr.Second)
+		})
+	}
+}
+
+func TestDuplicateJobNamesSources(t *testing.T) {
+	t.Parallel()
+
+	nrConfig, _, err := configurator.LoadFragments([]configurator.Fragment{
+		{Path: "jobs.yaml", Data: []byte(duplicateJobsConfig)},
+	}, false)
+	require.NoError(t, err)
+
+	_, err = configurator.BuildPromConfig(nrConfig)
+	require.EqualError(t, err, `invalid config: duplicate scrape job name "team-pod": generated by `+
+		`static_targets.jobs[0] (jobs.yaml:6) and kubernetes.jobs[0] pod job (jobs.yaml:10)`)
+}
+
+func TestSuffixedDuplicateJobNames(t *testing.T) {
+	t.Parallel()
+
+	nrConfig, _, err := configurator.DecodeNrConfig([]byte(duplicateJobsConfig), false)
+	require.NoError(t, err)
+
+	prometheusConfig, err := configurator.BuildPromConfig(nrConfig, configurator.WithSuffixedDuplicateJobNames(true))
+	require.NoError(t, err)
+
+	names := make([]string, 0, len(prometheusConfig.ScrapeConfigs))
+
+	for _, job := range prometheusConfig.ScrapeConfigs {
+		switch typed := job.(type) {
+		case promcfg.Job:
+			names = append(names, typed.JobName)
+		case map[string]any:
+			names = append(names, typed["job_name"].(string)) //nolint: forcetypeassert
+		}
+	}
+
+	// `team-pod-2` is already used by an extra scrape config, so the kubernetes job takes `team-pod-3`.
+	assert.Equal(t, []string{"team-pod", "team-pod-3", "team-endpoints", "team-endpoints-2", "team-pod-2"}, names)
+
+	// The nrConfig is not modified.
+	assert.Equal(t, "team-endpoints", nrConfig.ExtraScrapeConfigs[0].(map[string]any)["job_name"]) //nolint: forcetypeassert
+}
+
+func TestValidateDuplicateJobNames(t *testing.T) {
+	t.Parallel()
+
+	diags := configurator.ValidateYAML([]byte(duplicateJobsConfig), false)
+	require.Len(t, diags, 1)
+	assert.Equal(t, diagnostic.CodeDuplicateJobName, diags[0].Code)
+	assert.Equal(t, "kubernetes.jobs[0]", diags[0].Path)
+	assert.Equal(t, 10, diags[0].Line)
+}
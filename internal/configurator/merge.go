@@ -0,0 +1,263 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package configurator
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/diagnostic"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	staticTargetJobsPath   = "static_targets.jobs"
+	kubernetesJobsPath     = "kubernetes.jobs"
+	extraScrapeConfigsPath = "extra_scrape_configs"
+
+	nullTag = "!!null"
+)
+
+var (
+	ErrFragmentConflict = errors.New("conflicting values in nrConfig fragments")
+	ErrJobNameCollision = errors.New("job name defined in several nrConfig fragments")
+)
+
+// Fragment is a piece of nrConfig, usually the content of one of the input files.
+type Fragment struct {
+	// Path identifies the fragment in errors.
+	Path string
+	Data []byte
+}
+
+// Source locates an entry of the nrConfig in the fragment defining it.
+type Source struct {
+	File string
+	Line int
+}
+
+// String returns the location as `file:line`.
+func (s Source) String() string {
+	return s.File + ":" + strconv.Itoa(s.Line)
+}
+
+// LoadFragments decodes each of the fragments, rejecting unknown fields unless lenient is set, and merges them into a
+// single nrConfig in the order provided:
+//   - mappings are merged key by key,
+//   - lists are appended,
+//   - scalars set in several fragments must hold the same value,
+//   - jobs with the same name in different fragments are rejected.
+//
+// The source of each job is kept in the Sources of the returned nrConfig. The unknown fields returned, if any, have
+// their File set.
+func LoadFragments(fragments []Fragment, lenient bool) (*NrConfig, []UnknownField, error) {
+	m := merger{files: map[*yaml.Node]string{}}
+
+	var (
+		merged        *yaml.Node
+		unknownFields []UnknownField
+	)
+
+	for _, fragment := range fragments {
+		_, fragmentUnknownFields, err := DecodeNrConfig(fragment.Data, lenient)
+		for _, f := range fragmentUnknownFields {
+			f.File = fragment.Path
+			unknownFields = append(unknownFields, f)
+		}
+
+		if err != nil {
+			return nil, unknownFields, fmt.Errorf("%s: %w", fragment.Path, err)
+		}
+
+		root, err := m.parse(fragment)
+		if err != nil {
+			return nil, unknownFields, err
+		}
+
+		if merged, err = m.merge(merged, root, ""); err != nil {
+			return nil, unknownFields, err
+		}
+	}
+
+	nrConfig := &NrConfig{}
+
+	if merged != nil {
+		// Unknown fields were already checked on each fragment.
+		if err := merged.Decode(nrConfig); err != nil {
+			return nil, unknownFields, fmt.Errorf("yaml nrConfig could not be loaded: %w", err)
+		}
+	}
+
+	sources, err := m.jobSources(merged)
+	if err != nil {
+		return nil, unknownFields, err
+	}
+
+	nrConfig.Sources = sources
+
+	return nrConfig, unknownFields, nil
+}
+
+// merger merges the yaml trees of the fragments remembering the file of each node.
+type merger struct {
+	files map[*yaml.Node]string
+}
+
+// parse returns the root of the fragment, nil if empty.
+func (m *merger) parse(fragment Fragment) (*yaml.Node, error) {
+	document := &yaml.Node{}
+	if err := yaml.Unmarshal(fragment.Data, document); err != nil {
+		return nil, fmt.Errorf("%s: yaml nrConfig could not be loaded: %w", fragment.Path, err)
+	}
+
+	if len(document.Content) == 0 {
+		return nil, nil //nolint: nilnil
+	}
+
+	root := document.Content[0]
+	m.track(root, fragment.Path)
+
+	return root, nil
+}
+
+func (m *merger) track(node *yaml.Node, file string) {
+	m.files[node] = file
+	for _, child := range node.Content {
+		m.track(child, file)
+	}
+}
+
+func (m *merger) source(node *yaml.Node) Source {
+	return Source{File: m.files[node], Line: node.Line}
+}
+
+// merge merges src into dst, the path of the nodes is used in errors.
+func (m *merger) merge(dst *yaml.Node, src *yaml.Node, path string) (*yaml.Node, error) {
+	switch {
+	case isNull(src):
+		return dst, nil
+	case isNull(dst):
+		return src, nil
+	case dst.Kind != src.Kind:
+		return nil, fmt.Errorf("%w: %s has a different type in %s and %s", ErrFragmentConflict, path, m.source(dst), m.source(src))
+	}
+
+	switch src.Kind {
+	case yaml.MappingNode:
+		return m.mergeMappings(dst, src, path)
+	case yaml.SequenceNode:
+		dst.Content = append(dst.Content, src.Content...)
+		return dst, nil
+	case yaml.ScalarNode:
+		if dst.Value != src.Value {
+			// Values are not included since they could be secrets like the license key.
+			return nil, fmt.Errorf("%w: %s is set to different values in %s and %s", ErrFragmentConflict, path, m.source(dst), m.source(src))
+		}
+
+		return dst, nil
+	default:
+		return nil, fmt.Errorf("%w: %s cannot be merged, it is defined in %s and %s", ErrFragmentConflict, path, m.source(dst), m.source(src))
+	}
+}
+
+func (m *merger) mergeMappings(dst *yaml.Node, src *yaml.Node, path string) (*yaml.Node, error) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+
+		index := mappingIndex(dst, key.Value)
+		if index < 0 {
+			dst.Content = append(dst.Content, key, value)
+			continue
+		}
+
+		merged, err := m.merge(dst.Content[index], value, diagnostic.JoinPath(path, key.Value))
+		if err != nil {
+			return nil, err
+		}
+
+		dst.Content[index] = merged
+	}
+
+	return dst, nil
+}
+
+// jobSources returns the source of every job, keyed by their path like `static_targets.jobs[1]`, and checks that
+// no job name is defined in several fragments.
+func (m *merger) jobSources(root *yaml.Node) (map[string]Source, error) {
+	sources := map[string]Source{}
+
+	// Static targets and extra scrape configs set the job name, kubernetes jobs a prefix for the names.
+	jobLists := []struct {
+		path      string
+		nameField string
+	}{
+		{path: staticTargetJobsPath, nameField: "job_name"},
+		{path: extraScrapeConfigsPath, nameField: "job_name"},
+		{path: kubernetesJobsPath, nameField: "job_name_prefix"},
+	}
+
+	seen := map[string]Source{}
+
+	for _, list := range jobLists {
+		jobs := lookup(root, list.path)
+		if jobs == nil || jobs.Kind != yaml.SequenceNode {
+			continue
+		}
+
+		for i, job := range jobs.Content {
+			source := m.source(job)
+			sources[fmt.Sprintf("%s[%d]", list.path, i)] = source
+
+			name := lookup(job, list.nameField)
+			if name == nil || name.Value == "" {
+				continue
+			}
+
+			key := list.nameField + "/" + name.Value
+			if previous, ok := seen[key]; ok && previous.File != source.File {
+				return nil, fmt.Errorf("%w: %s %q is defined in %s and %s", ErrJobNameCollision, list.nameField, name.Value, previous, source)
+			}
+
+			seen[key] = source
+		}
+	}
+
+	return sources, nil
+}
+
+// lookup returns the node at the dot separated path of mapping keys, nil if not found.
+func lookup(node *yaml.Node, path string) *yaml.Node {
+	for _, key := range strings.Split(path, ".") {
+		if node == nil || node.Kind != yaml.MappingNode {
+			return nil
+		}
+
+		index := mappingIndex(node, key)
+		if index < 0 {
+			return nil
+		}
+
+		node = node.Content[index]
+	}
+
+	return node
+}
+
+// mappingIndex returns the index in the Content of the mapping node of the value for the key, -1 if not found.
+func mappingIndex(node *yaml.Node, key string) int {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return i + 1
+		}
+	}
+
+	return -1
+}
+
+func isNull(node *yaml.Node) bool {
+	return node == nil || (node.Kind == yaml.ScalarNode && node.ShortTag() == nullTag)
+}
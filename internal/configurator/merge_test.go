@@ -0,0 +1,165 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package configurator_test
+
+import (
+	"testing"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/configurator"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/diagnostic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const baseFragment = `
+common:
+  scrape_interval: 30s
+  external_labels:
+    cluster_name: prod
+newrelic_remote_write:
+  license_key: nrLicenseKey
+static_targets:
+  jobs:
+  - job_name: base
+    targets: ["base:80"]
+kubernetes:
+`
+
+const teamFragment = `
+common:
+  scrape_interval: 30s
+  external_labels:
+    team: core
+static_targets:
+  jobs:
+  - job_name: team-a
+    targets: ["a:80"]
+kubernetes:
+  jobs:
+  - job_name_prefix: team-a
+    target_discovery:
+      pod: true
+`
+
+func TestLoadFragments(t *testing.T) {
+	t.Parallel()
+
+	nrConfig, unknownFields, err := configurator.LoadFragments([]configurator.Fragment{
+		{Path: "base.yaml", Data: []byte(baseFragment)},
+		{Path: "empty.yaml"},
+		{Path: "team-a.yaml", Data: []byte(teamFragment)},
+	}, false)
+	require.NoError(t, err)
+	assert.Empty(t, unknownFields)
+
+	assert.Equal(t, "nrLicenseKey", nrConfig.RemoteWrite.LicenseKey)
+	assert.Equal(t, map[string]string{"cluster_name": "prod", "team": "core"}, nrConfig.Common.ExternalLabels)
+
+	require.Len(t, nrConfig.StaticTargets.StaticTargetJobs, 2)
+	assert.Equal(t, "base", nrConfig.StaticTargets.StaticTargetJobs[0].ScrapeJob.JobName)
+	assert.Equal(t, "team-a", nrConfig.StaticTargets.StaticTargetJobs[1].ScrapeJob.JobName)
+	require.Len(t, nrConfig.Kubernetes.K8sJobs, 1)
+
+	assert.Equal(t, map[string]configurator.Source{
+		"static_targets.jobs[0]": {File: "base.yaml", Line: 10},
+		"static_targets.jobs[1]": {File: "team-a.yaml", Line: 8},
+		"kubernetes.jobs[0]":     {File: "team-a.yaml", Line: 12},
+	}, nrConfig.Sources)
+}
+
+func TestLoadFragmentsErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		fragment string
+		err      error
+		msg      string
+	}{
+		{
+			name:     "ScalarConflict",
+			fragment: "common:\n  scrape_interval: 1m\n",
+			err:      configurator.ErrFragmentConflict,
+			msg:      "common.scrape_interval is set to different values in base.yaml:3 and other.yaml:2",
+		},
+		{
+			name:     "JobNameCollision",
+			fragment: "static_targets:\n  jobs:\n  - job_name: base\n    targets: [\"other:80\"]\n",
+			err:      configurator.ErrJobNameCollision,
+			msg:      `job_name "base" is defined in base.yaml:10 and other.yaml:3`,
+		},
+		{
+			name:     "CollisionWithExtraScrapeConfig",
+			fragment: "extra_scrape_configs:\n- job_name: base\n",
+			err:      configurator.ErrJobNameCollision,
+			msg:      `job_name "base" is defined in base.yaml:10 and other.yaml:2`,
+		},
+		{
+			name:     "UnknownField",
+			fragment: "comon:\n  scrape_interval: 1m\n",
+			err:      configurator.ErrUnknownFields,
+			msg:      "other.yaml: ",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, _, err := configurator.LoadFragments([]configurator.Fragment{
+				{Path: "base.yaml", Data: []byte(baseFragment)},
+				{Path: "other.yaml", Data: []byte(tc.fragment)},
+			}, false)
+			require.ErrorIs(t, err, tc.err)
+			assert.Contains(t, err.Error(), tc.msg)
+		})
+	}
+}
+
+func TestLoadFragmentsLenient(t *testing.T) {
+	t.Parallel()
+
+	_, unknownFields, err := configurator.LoadFragments([]configurator.Fragment{
+		{Path: "base.yaml", Data: []byte(baseFragment)},
+		{Path: "other.yaml", Data: []byte("comon:\n  scrape_interval: 1m\n")},
+	}, true)
+	require.NoError(t, err)
+	require.Len(t, unknownFields, 1)
+	assert.Equal(t, `other.yaml: line 1: unknown field "comon" in configurator.NrConfig, did you mean "common"?`, unknownFields[0].String())
+}
+
+func TestValidateFragments(t *testing.T) {
+	t.Parallel()
+
+	diags := configurator.ValidateFragments([]configurator.Fragment{
+		{Path: "base.yaml", Data: []byte(baseFragment)},
+		{Path: "team-a.yaml", Data: []byte(teamFragment)},
+		{Path: "team-b.yaml", Data: []byte("kubernetes:\n  jobs:\n  - job_name_prefix: team-b\n    target_discovery:\n      podd: true\n")},
+	}, false)
+
+	require.Len(t, diags, 2)
+
+	assert.Equal(t, diagnostic.CodeUnknownField, diags[0].Code)
+	assert.Equal(t, "team-b.yaml", diags[0].File)
+	assert.Equal(t, "kubernetes.jobs[0].target_discovery.podd", diags[0].Path)
+	assert.Equal(t, 5, diags[0].Line)
+
+	assert.Equal(t, diagnostic.CodeInvalidK8sJobKinds, diags[1].Code)
+	assert.Equal(t, "team-b.yaml", diags[1].File)
+	assert.Equal(t, "kubernetes.jobs[1].target_discovery", diags[1].Path)
+	assert.Equal(t, 4, diags[1].Line)
+}
+
+func TestValidateFragmentsConflict(t *testing.T) {
+	t.Parallel()
+
+	diags := configurator.ValidateFragments([]configurator.Fragment{
+		{Path: "base.yaml", Data: []byte(baseFragment)},
+		{Path: "other.yaml", Data: []byte("common:\n  scrape_interval: 1m\n")},
+	}, false)
+
+	require.Len(t, diags, 1)
+	assert.Equal(t, diagnostic.CodeFragmentConflict, diags[0].Code)
+	assert.Contains(t, diags[0].Message, "common.scrape_interval is set to different values in base.yaml:3 and other.yaml:2")
+}
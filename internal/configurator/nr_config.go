@@ -30,4 +30,7 @@ type NrConfig struct {
 	ExtraScrapeConfigs []RawPromConfig `yaml:"extra_scrape_configs"`
 	// Kubernetes holds the kubernetes-targets' configuration.
 	Kubernetes kubernetes.Config `yaml:"kubernetes"`
+	// Sources holds the file and line defining each job when loaded with LoadFragments, keyed by the path of the job
+	// like `static_targets.jobs[1]`.
+	Sources map[string]Source `yaml:"-"`
 }
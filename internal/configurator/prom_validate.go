@@ -0,0 +1,126 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package configurator
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	prometheusConfig "github.com/prometheus/prometheus/config"
+	// Registers the kubernetes service discovery so `kubernetes_sd_configs` can be parsed.
+	_ "github.com/prometheus/prometheus/discovery/kubernetes"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalidPromConfig = errors.New("the generated prometheus config is not valid")
+
+const (
+	globalSection        = "global"
+	scrapeConfigsSection = "scrape_configs"
+	remoteWriteSection   = "remote_write"
+)
+
+// PromConfigError is returned when the generated config is rejected by the Prometheus config parser. When the problem
+// can be attributed to a single scrape job or remote write, Section and Name point to it.
+type PromConfigError struct {
+	// Section is the top-level key of the Prometheus config holding the invalid entry, like `scrape_configs`.
+	// It is empty when the problem could not be attributed.
+	Section string
+	// Name is the `job_name` of the scrape config or the `name` of the remote write.
+	Name string
+	Err  error
+}
+
+func (e *PromConfigError) Error() string {
+	switch {
+	case e.Section == "":
+		return fmt.Sprintf("%s: %s", ErrInvalidPromConfig, e.Err)
+	case e.Name == "":
+		return fmt.Sprintf("%s, in %s: %s", ErrInvalidPromConfig, e.Section, e.Err)
+	default:
+		return fmt.Sprintf("%s, %s %q: %s", ErrInvalidPromConfig, e.Section, e.Name, e.Err)
+	}
+}
+
+func (e *PromConfigError) Unwrap() []error {
+	return []error{ErrInvalidPromConfig, e.Err}
+}
+
+// ValidatePromConfig loads the generated config with the upstream Prometheus parser, so configs which would make
+// Prometheus fail to start are detected before being written. Raw `extra_scrape_configs` and `extra_remote_write` are
+// only checked here, since the configurator copies them as they are.
+func ValidatePromConfig(data []byte) error {
+	err := loadPromConfig(data)
+	if err == nil {
+		return nil
+	}
+
+	configErr := &PromConfigError{Err: err}
+	configErr.Section, configErr.Name = locateInvalidEntry(data)
+
+	return configErr
+}
+
+func loadPromConfig(data []byte) error {
+	// The parser only logs when expanding external labels, which are not generated by the configurator.
+	if _, err := prometheusConfig.Load(string(data), slog.New(slog.DiscardHandler)); err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	return nil
+}
+
+// locateInvalidEntry loads every scrape config and remote write on its own, together with the global config since
+// scrape configs inherit defaults from it, and returns the first one rejected by the parser. Problems involving
+// several entries, like duplicated job names, are not attributed to any of them.
+func locateInvalidEntry(data []byte) (string, string) {
+	config := struct {
+		Global        any              `yaml:"global,omitempty"`
+		ScrapeConfigs []map[string]any `yaml:"scrape_configs"`
+		RemoteWrite   []map[string]any `yaml:"remote_write"`
+	}{}
+
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return "", ""
+	}
+
+	if config.Global != nil && !loads(map[string]any{globalSection: config.Global}) {
+		return globalSection, ""
+	}
+
+	for _, scrapeConfig := range config.ScrapeConfigs {
+		partial := map[string]any{globalSection: config.Global, scrapeConfigsSection: []any{scrapeConfig}}
+		if !loads(partial) {
+			return scrapeConfigsSection, stringValue(scrapeConfig["job_name"])
+		}
+	}
+
+	for _, remoteWrite := range config.RemoteWrite {
+		partial := map[string]any{globalSection: config.Global, remoteWriteSection: []any{remoteWrite}}
+		if !loads(partial) {
+			return remoteWriteSection, stringValue(remoteWrite["name"])
+		}
+	}
+
+	return "", ""
+}
+
+// loads returns true if the partial config is accepted by the Prometheus config parser.
+func loads(partial map[string]any) bool {
+	data, err := yaml.Marshal(partial)
+	if err != nil {
+		return false
+	}
+
+	return loadPromConfig(data) == nil
+}
+
+func stringValue(value any) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+
+	return ""
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package configurator_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/configurator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePromConfig(t *testing.T) {
+	t.Parallel()
+
+	const global = `
+global:
+  scrape_interval: 30s
+`
+
+	testCases := []struct {
+		name            string
+		config          string
+		expectedSection string
+		expectedName    string
+		valid           bool
+	}{
+		{
+			name: "Valid",
+			config: global + `
+remote_write:
+- name: newrelic_rw
+  url: https://metric-api.newrelic.com/prometheus/v1/write
+scrape_configs:
+- job_name: self
+  static_configs:
+  - targets: [localhost:9090]
+`,
+			valid: true,
+		},
+		{
+			name: "InvalidScrapeConfig",
+			config: global + `
+scrape_configs:
+- job_name: self
+  static_configs:
+  - targets: [localhost:9090]
+- job_name: broken
+  scrape_interval: never
+`,
+			expectedSection: "scrape_configs",
+			expectedName:    "broken",
+		},
+		{
+			name: "InvalidRemoteWrite",
+			config: global + `
+remote_write:
+- name: newrelic_rw
+  url: https://metric-api.newrelic.com/prometheus/v1/write
+- name: extra
+`,
+			expectedSection: "remote_write",
+			expectedName:    "extra",
+		},
+		{
+			name: "InvalidGlobal",
+			config: `
+global:
+  scrape_interval: never
+scrape_configs:
+- job_name: self
+`,
+			expectedSection: "global",
+		},
+		{
+			name: "DuplicatedJobNamesAreNotAttributed",
+			config: global + `
+scrape_configs:
+- job_name: self
+- job_name: self
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := configurator.ValidatePromConfig([]byte(tc.config))
+			if tc.valid {
+				require.NoError(t, err)
+				return
+			}
+
+			require.ErrorIs(t, err, configurator.ErrInvalidPromConfig)
+
+			var configErr *configurator.PromConfigError
+			require.True(t, errors.As(err, &configErr))
+			assert.Equal(t, tc.expectedSection, configErr.Section)
+			assert.Equal(t, tc.expectedName, configErr.Name)
+		})
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package configurator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/diagnostic"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/redact"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/sharding"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidateYAML decodes the nrConfig yaml and validates it, the returned diagnostics include the position of each
+// problem in the yaml data. Unknown fields are reported as errors unless lenient is true, then they are warnings.
+func ValidateYAML(data []byte, lenient bool) diagnostic.List {
+	return ValidateFragments([]Fragment{{Data: data}}, lenient)
+}
+
+// ValidateFragments validates the nrConfig merged from the fragments as LoadFragments does. Decoding problems are
+// reported for each of the fragments, then the merged nrConfig is validated. The diagnostics have their File set to
+// the Path of the fragment holding the problem.
+func ValidateFragments(fragments []Fragment, lenient bool) diagnostic.List {
+	m := merger{files: map[*yaml.Node]string{}}
+
+	var (
+		diags       diagnostic.List
+		roots       []*yaml.Node
+		syntaxError bool
+	)
+
+	for _, fragment := range fragments {
+		document := &yaml.Node{}
+		if err := yaml.Unmarshal(fragment.Data, document); err != nil {
+			diags = append(diags, withFile(diagnostic.FromYAMLError(err), fragment.Path)...)
+			syntaxError = true
+
+			continue
+		}
+
+		locator := diagnostic.NewNodeLocator(document)
+		fragmentDiags := decodeDiagnostics(fragment.Data, lenient, locator)
+		fragmentDiags.Locate(locator)
+		diags = append(diags, withFile(fragmentDiags, fragment.Path)...)
+
+		if len(document.Content) > 0 {
+			root := document.Content[0]
+			m.track(root, fragment.Path)
+			roots = append(roots, root)
+		}
+	}
+
+	if syntaxError {
+		return diags
+	}
+
+	var merged *yaml.Node
+
+	for _, root := range roots {
+		var err error
+		if merged, err = m.merge(merged, root, ""); err != nil {
+			return append(diags, diagnostic.Error(diagnostic.CodeFragmentConflict, "", err))
+		}
+	}
+
+	nrConfig := &NrConfig{}
+
+	if merged != nil {
+		// Type errors were already reported for each of the fragments, the rest of the fields are still validated.
+		_ = merged.Decode(nrConfig)
+	}
+
+	sources, err := m.jobSources(merged)
+	if err != nil {
+		return append(diags, diagnostic.Error(diagnostic.CodeFragmentConflict, "", err))
+	}
+
+	nrConfig.Sources = sources
+
+	configDiags := Validate(nrConfig)
+	configDiags.Locate(diagnostic.NewMergedLocator(merged, m.files))
+
+	return append(diags, configDiags...)
+}
+
+// decodeDiagnostics reports the problems found decoding the nrConfig yaml, like values of the wrong type or unknown
+// fields. The locator of the yaml resolves the path of the unknown fields.
+func decodeDiagnostics(data []byte, lenient bool, locator *diagnostic.Locator) diagnostic.List {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err := decoder.Decode(&NrConfig{})
+	if err == nil || errors.Is(err, io.EOF) {
+		return nil
+	}
+
+	var diags diagnostic.List
+
+	unknownFields, err := splitUnknownFields(err)
+	if err != nil {
+		diags = diagnostic.FromYAMLError(err)
+	}
+
+	return append(diags, unknownFieldsDiagnostics(unknownFields, lenient, locator)...)
+}
+
+// withFile sets the file of the diagnostics.
+func withFile(diags diagnostic.List, file string) diagnostic.List {
+	for i := range diags {
+		diags[i].File = file
+	}
+
+	return diags
+}
+
+// Validate checks the nrConfig and reports every problem found, while BuildPromConfig stops at the first one.
+// Values defined by environment variables are expanded before validating, as BuildPromConfig does, in a copy so the
+// nrConfig is not modified.
+func Validate(nrConfig *NrConfig) diagnostic.List {
+	var diags diagnostic.List
+
+	config := copyForExpand(nrConfig)
+
+	// placeholderShardIndex is used to build the config when the index is only known at runtime.
+	var placeholderShardIndex string
+
+	if err := expand(config); err != nil {
+		diags = append(diags, diagnostic.Error(diagnostic.CodeInvalidLicenseKeyFile, "newrelic_remote_write.license_key_file", err))
+	} else if config.RemoteWrite.LicenseKey == "" && config.RemoteWrite.CatchAllEnabled() {
+		diags = append(diags, diagnostic.Error(diagnostic.CodeMissingLicenseKey, "newrelic_remote_write.license_key", ErrNoLicenseKeyFound))
+	}
+
+	switch err := validateSharding(config.Sharding); {
+	case errors.Is(err, ErrInvalidShardingKind):
+		diags = append(diags, diagnostic.Error(diagnostic.CodeInvalidShardingKind, "sharding.kind", err))
+	case errors.Is(err, ErrNodeShardsCount):
+		diags = append(diags, diagnostic.Error(diagnostic.CodeInvalidNodeSharding, "sharding.total_shards_count", err))
+	case errors.Is(err, ErrInvalidShardIndex) && config.Sharding.ShardIndex == "":
+		// The index is usually resolved where the configurator runs, any shard allows checking the rest of the config.
+		diags = append(diags, diagnostic.Warning(diagnostic.CodeInvalidShardIndex, "sharding.shard_index", fmt.Sprintf(
+			"shard_index is not set, it must be provided at runtime by the %s or %s environment variables",
+			ShardIndexEnvKey, DataSourceNameEnvKey,
+		)))
+		placeholderShardIndex = "0"
+	case errors.Is(err, ErrInvalidShardIndex):
+		diags = append(diags, diagnostic.Error(diagnostic.CodeInvalidShardIndex, "sharding.shard_index", err))
+	case errors.Is(err, ErrNamespaceSharding):
+		path := "sharding.pinned_namespaces"
+		if config.Sharding.Kind == sharding.KindNamespace && len(config.Sharding.HashSourceLabels) > 0 {
+			path = "sharding.hash_source_labels"
+		}
+
+		diags = append(diags, diagnostic.Error(diagnostic.CodeInvalidNamespaceSharding, path, err))
+	case errors.Is(err, ErrNoNodeName):
+		diags = append(diags, diagnostic.Error(diagnostic.CodeInvalidNodeSharding, "sharding.node_name", err))
+	case err != nil:
+		diags = append(diags, diagnostic.Error(diagnostic.CodeBuild, "sharding", err))
+	}
+
+	for _, problem := range nodeUnrestrictedJobs(config) {
+		diags = append(diags, diagnostic.Error(diagnostic.CodeInvalidNodeSharding, problem.path, problem.err))
+	}
+
+	if _, err := config.RemoteWrite.BuildAll(); err != nil {
+		diags = append(diags, diagnostic.Error(diagnostic.CodeInvalidRemoteWrite, "newrelic_remote_write", err))
+	}
+
+	for _, setting := range config.RemoteWrite.UnsupportedSettings() {
+		diags = append(diags, diagnostic.Warning(
+			diagnostic.CodeUnsupportedRemoteWriteSetting, "newrelic_remote_write."+setting.Field, setting.Reason,
+		))
+	}
+
+	diags = append(diags, config.Kubernetes.Validate().WithPathPrefix("kubernetes")...)
+
+	// Any problem not covered by the checks above is still reported, so a valid result guarantees the config builds
+	// and is accepted by Prometheus.
+	if !diags.HasErrors() {
+		diags = append(diags, validateBuild(nrConfig, placeholderShardIndex)...)
+	}
+
+	// Messages may quote values from the config, like the Prometheus parser errors.
+	secrets := redact.Secrets(config)
+	for i := range diags {
+		diags[i].Message = redact.String(diags[i].Message, secrets)
+	}
+
+	return diags
+}
+
+// validateBuild builds the nrConfig, with the shardIndex instead of its own one if set, and checks the result is
+// accepted by Prometheus.
+func validateBuild(nrConfig *NrConfig, shardIndex string) diagnostic.List {
+	config := copyForExpand(nrConfig)
+	if shardIndex != "" {
+		config.Sharding.ShardIndex = shardIndex
+	}
+
+	prometheusConfig, err := BuildPromConfig(config)
+
+	var duplicateErr *DuplicateJobNameError
+	if errors.As(err, &duplicateErr) {
+		return diagnostic.List{diagnostic.Error(diagnostic.CodeDuplicateJobName, duplicateErr.Second.Path, duplicateErr)}
+	}
+
+	if err != nil {
+		return diagnostic.List{diagnostic.Error(diagnostic.CodeBuild, "", err)}
+	}
+
+	data, err := yaml.Marshal(prometheusConfig)
+	if err != nil {
+		return diagnostic.List{diagnostic.Error(diagnostic.CodeBuild, "", err)}
+	}
+
+	var configErr *PromConfigError
+	if err := ValidatePromConfig(data); errors.As(err, &configErr) {
+		return diagnostic.List{diagnostic.Error(diagnostic.CodeInvalidPromConfig, extraConfigPath(nrConfig, configErr), err)}
+	}
+
+	return nil
+}
+
+// copyForExpand returns a copy of the nrConfig not sharing the values set by expand and BuildPromConfig with it.
+func copyForExpand(nrConfig *NrConfig) *NrConfig {
+	config := *nrConfig
+	config.RemoteWrite.Accounts = slices.Clone(nrConfig.RemoteWrite.Accounts)
+
+	return &config
+}
+
+// extraConfigPath returns the path of the raw extra config matching the entry rejected by Prometheus, generated
+// entries are validated by the configurator itself so they are not located.
+func extraConfigPath(nrConfig *NrConfig, configErr *PromConfigError) string {
+	var section string
+
+	var entries []RawPromConfig
+
+	var nameKey string
+
+	switch configErr.Section {
+	case scrapeConfigsSection:
+		section, entries, nameKey = "extra_scrape_configs", nrConfig.ExtraScrapeConfigs, "job_name"
+	case remoteWriteSection:
+		section, entries, nameKey = "extra_remote_write", nrConfig.ExtraRemoteWrite, "name"
+	default:
+		return ""
+	}
+
+	for i, entry := range entries {
+		if fields, ok := entry.(map[string]any); ok && stringValue(fields[nameKey]) == configErr.Name {
+			return fmt.Sprintf("%s[%d]", section, i)
+		}
+	}
+
+	return ""
+}
+
+// unknownFieldsDiagnostics reports the unknown fields at the path and column of their key.
+func unknownFieldsDiagnostics(unknownFields []UnknownField, lenient bool, locator *diagnostic.Locator) diagnostic.List {
+	diags := make(diagnostic.List, 0, len(unknownFields))
+
+	for _, f := range unknownFields {
+		path, column := locator.FindKey(f.Line, f.Field)
+
+		d := diagnostic.Diagnostic{
+			Severity: diagnostic.SeverityError,
+			Code:     diagnostic.CodeUnknownField,
+			Path:     path,
+			Line:     f.Line,
+			Column:   column,
+			Message:  f.Description(),
+		}
+
+		if lenient {
+			d.Severity = diagnostic.SeverityWarning
+		}
+
+		diags = append(diags, d)
+	}
+
+	return diags
+}
@@ -0,0 +1,252 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package diagnostic holds the types used to report problems found in the configuration, including where they are
+// located in the source yaml.
+package diagnostic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Severity tells if a diagnostic prevents the configuration from being used.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Code identifies the kind of problem. Codes are stable across releases so tooling can rely on them.
+type Code string
+
+const (
+	// CodeYAMLSyntax is reported when the input is not valid yaml.
+	CodeYAMLSyntax Code = "NRC001"
+	// CodeInvalidType is reported when a value cannot be decoded into the type of its field.
+	CodeInvalidType Code = "NRC002"
+	// CodeUnknownField is reported when a key does not match any supported field.
+	CodeUnknownField Code = "NRC003"
+	// CodeFragmentConflict is reported when the input files set different values for a field or define the same job.
+	CodeFragmentConflict Code = "NRC004"
+
+	// CodeMissingLicenseKey is reported when no license key is set in the config or the environment.
+	CodeMissingLicenseKey Code = "NRC101"
+	// CodeInvalidShardingKind is reported when the sharding kind is not supported.
+	CodeInvalidShardingKind Code = "NRC102"
+	// CodeInvalidRemoteWrite is reported when the New Relic remote write settings are not compatible.
+	CodeInvalidRemoteWrite Code = "NRC103"
+	// CodeDuplicateJobName is reported when several scrape jobs are generated with the same name.
+	CodeDuplicateJobName Code = "NRC104"
+	// CodeInvalidLicenseKeyFile is reported when the license key file cannot be read or is set along with the key.
+	CodeInvalidLicenseKeyFile Code = "NRC105"
+	// CodeUnsupportedRemoteWriteSetting is reported when a New Relic remote write setting has no effect on its endpoint.
+	CodeUnsupportedRemoteWriteSetting Code = "NRC106"
+	// CodeInvalidNodeSharding is reported when the node sharding has no node name, sets the hash sharding fields or
+	// static targets and extra scrape configs not setting skip_sharding.
+	CodeInvalidNodeSharding Code = "NRC107"
+	// CodeInvalidNamespaceSharding is reported when the namespaces are pinned to shards not available or along with
+	// hash sharding fields.
+	CodeInvalidNamespaceSharding Code = "NRC108"
+	// CodeInvalidShardIndex is reported when the shard index is not one of the shards, or is not set.
+	CodeInvalidShardIndex Code = "NRC109"
+
+	// CodeInvalidK8sJobKinds is reported when a kubernetes job has no target kinds enabled.
+	CodeInvalidK8sJobKinds Code = "NRC201"
+	// CodeInvalidK8sJobPrefix is reported when a kubernetes job has no job name prefix.
+	CodeInvalidK8sJobPrefix Code = "NRC202"
+	// CodeInvalidSkipSharding is reported when a kubernetes job sets the skip_sharding flag.
+	CodeInvalidSkipSharding Code = "NRC203"
+	// CodeInvalidIntegrationFilter is reported when an enabled integrations filter has no source labels or values.
+	CodeInvalidIntegrationFilter Code = "NRC204"
+
+	// CodeBuild is reported when building the prometheus config fails for a reason not covered by other codes.
+	CodeBuild Code = "NRC900"
+	// CodeInvalidPromConfig is reported when the generated config is rejected by the Prometheus config parser.
+	CodeInvalidPromConfig Code = "NRC901"
+)
+
+// Diagnostic describes a problem found in the configuration.
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code     Code     `json:"code"`
+	// File is the input file holding the problem, set when the config is loaded from several files.
+	File string `json:"file,omitempty"`
+	// Path is the yaml path of the field causing the problem, like `kubernetes.jobs[0].job_name_prefix`.
+	Path    string `json:"path,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error returns an error Diagnostic for the provided path.
+func Error(code Code, path string, err error) Diagnostic {
+	return Diagnostic{Severity: SeverityError, Code: code, Path: path, Message: err.Error()}
+}
+
+// Warning returns a warning Diagnostic for the provided path.
+func Warning(code Code, path string, message string) Diagnostic {
+	return Diagnostic{Severity: SeverityWarning, Code: code, Path: path, Message: message}
+}
+
+// String formats the diagnostic as `<line>:<column>: <severity> [<code>] <path>: <message>`.
+func (d Diagnostic) String() string {
+	var sb strings.Builder
+
+	if d.Line > 0 {
+		sb.WriteString(strconv.Itoa(d.Line))
+		if d.Column > 0 {
+			sb.WriteString(":" + strconv.Itoa(d.Column))
+		}
+		sb.WriteString(": ")
+	}
+
+	fmt.Fprintf(&sb, "%s [%s] ", d.Severity, d.Code)
+
+	if d.Path != "" {
+		sb.WriteString(d.Path + ": ")
+	}
+
+	sb.WriteString(d.Message)
+
+	return sb.String()
+}
+
+// List is a collection of diagnostics.
+type List []Diagnostic
+
+// HasErrors returns true if any of the diagnostics has error severity.
+func (l List) HasErrors() bool {
+	for _, d := range l {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithPathPrefix returns a copy of the list having the prefix prepended to all the paths.
+func (l List) WithPathPrefix(prefix string) List {
+	prefixed := make(List, 0, len(l))
+
+	for _, d := range l {
+		d.Path = JoinPath(prefix, d.Path)
+		prefixed = append(prefixed, d)
+	}
+
+	return prefixed
+}
+
+// Locate fills the file, line and column of the diagnostics which do not have a line yet.
+func (l List) Locate(locator *Locator) {
+	for i := range l {
+		if l[i].Line != 0 {
+			continue
+		}
+
+		l[i].Line, l[i].Column = locator.Locate(l[i].Path)
+
+		if l[i].File == "" {
+			l[i].File = locator.File(l[i].Path)
+		}
+	}
+}
+
+// WriteText writes one diagnostic per line prefixed by its file, or the source name if it has none.
+func (l List) WriteText(w io.Writer, source string) error {
+	for _, d := range l {
+		file := source
+		if d.File != "" {
+			file = d.File
+		}
+
+		if _, err := fmt.Fprintf(w, "%s:%s\n", file, d.String()); err != nil {
+			return fmt.Errorf("writing diagnostic: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Report is the JSON representation of the diagnostics of a source.
+type Report struct {
+	Source      string       `json:"source"`
+	Valid       bool         `json:"valid"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// WriteJSON writes the diagnostics as a JSON Report.
+func (l List) WriteJSON(w io.Writer, source string) error {
+	report := Report{
+		Source:      source,
+		Valid:       !l.HasErrors(),
+		Diagnostics: l,
+	}
+
+	if report.Diagnostics == nil {
+		report.Diagnostics = []Diagnostic{}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(report); err != nil {
+		return fmt.Errorf("encoding diagnostics: %w", err)
+	}
+
+	return nil
+}
+
+// JoinPath joins two yaml paths.
+func JoinPath(prefix string, path string) string {
+	switch {
+	case prefix == "":
+		return path
+	case path == "":
+		return prefix
+	case strings.HasPrefix(path, "["):
+		return prefix + path
+	default:
+		return prefix + "." + path
+	}
+}
+
+// yamlLineRegex matches the line reported by the yaml library in its error messages.
+var yamlLineRegex = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// FromYAMLError converts an error returned when decoding yaml into diagnostics. Decoding errors of type
+// `*yaml.TypeError` can hold several problems, each of them is reported separately.
+func FromYAMLError(err error) List {
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		diags := make(List, 0, len(typeErr.Errors))
+		for _, msg := range typeErr.Errors {
+			diags = append(diags, fromYAMLMessage(CodeInvalidType, msg))
+		}
+
+		return diags
+	}
+
+	return List{fromYAMLMessage(CodeYAMLSyntax, err.Error())}
+}
+
+func fromYAMLMessage(code Code, msg string) Diagnostic {
+	d := Diagnostic{Severity: SeverityError, Code: code, Message: msg}
+
+	if matches := yamlLineRegex.FindStringSubmatch(msg); matches != nil {
+		d.Line, _ = strconv.Atoi(matches[1])
+		d.Message = matches[2]
+	}
+
+	return d
+}
@@ -0,0 +1,117 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package diagnostic_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/diagnostic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const locatorYAML = `kubernetes:
+  jobs:
+  - job_name_prefix: first
+  - job_name_prefix: second
+    target_discovery:
+      pod: true
+`
+
+func TestLocate(t *testing.T) {
+	t.Parallel()
+
+	locator, err := diagnostic.NewLocator([]byte(locatorYAML))
+	require.NoError(t, err)
+
+	cases := []struct {
+		path   string
+		line   int
+		column int
+	}{
+		{path: "kubernetes", line: 1, column: 1},
+		{path: "kubernetes.jobs[0]", line: 3, column: 5},
+		{path: "kubernetes.jobs[1].target_discovery.pod", line: 6, column: 7},
+		// Missing elements are located at their closest parent.
+		{path: "kubernetes.jobs[0].target_discovery", line: 3, column: 5},
+		{path: "kubernetes.jobs[5]", line: 2, column: 3},
+		{path: "sharding.kind", line: 1, column: 1},
+	}
+
+	for _, tc := range cases {
+		line, column := locator.Locate(tc.path)
+		assert.Equal(t, tc.line, line, tc.path)
+		assert.Equal(t, tc.column, column, tc.path)
+	}
+}
+
+func TestFindKey(t *testing.T) {
+	t.Parallel()
+
+	locator, err := diagnostic.NewLocator([]byte(locatorYAML))
+	require.NoError(t, err)
+
+	path, column := locator.FindKey(6, "pod")
+	assert.Equal(t, "kubernetes.jobs[1].target_discovery.pod", path)
+	assert.Equal(t, 7, column)
+
+	path, column = locator.FindKey(5, "pod")
+	assert.Empty(t, path)
+	assert.Zero(t, column)
+}
+
+func TestFromYAMLError(t *testing.T) {
+	t.Parallel()
+
+	var out struct {
+		Count int `yaml:"count"`
+		Other int `yaml:"other"`
+	}
+
+	err := yaml.Unmarshal([]byte("count: one\nother: two\n"), &out)
+	diags := diagnostic.FromYAMLError(err)
+	require.Len(t, diags, 2)
+	assert.Equal(t, diagnostic.CodeInvalidType, diags[0].Code)
+	assert.Equal(t, 1, diags[0].Line)
+	assert.Equal(t, 2, diags[1].Line)
+
+	err = yaml.Unmarshal([]byte("count: [1\n"), &out)
+	diags = diagnostic.FromYAMLError(err)
+	require.Len(t, diags, 1)
+	assert.Equal(t, diagnostic.CodeYAMLSyntax, diags[0].Code)
+}
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+
+	diags := diagnostic.List{
+		diagnostic.Error(diagnostic.CodeMissingLicenseKey, "license_key", errors.New("missing")),
+		diagnostic.Warning(diagnostic.CodeBuild, "", "something to check"),
+	}.WithPathPrefix("newrelic_remote_write")
+	diags[0].Line = 3
+	diags[0].Column = 2
+
+	require.True(t, diags.HasErrors())
+
+	text := &bytes.Buffer{}
+	require.NoError(t, diags.WriteText(text, "config.yaml"))
+	assert.Equal(t,
+		"config.yaml:3:2: error [NRC101] newrelic_remote_write.license_key: missing\n"+
+			"config.yaml:warning [NRC900] newrelic_remote_write: something to check\n",
+		text.String(),
+	)
+
+	jsonData := &bytes.Buffer{}
+	require.NoError(t, diags.WriteJSON(jsonData, "config.yaml"))
+
+	report := diagnostic.Report{}
+	require.NoError(t, json.Unmarshal(jsonData.Bytes(), &report))
+	assert.False(t, report.Valid)
+	assert.Equal(t, "config.yaml", report.Source)
+	assert.Equal(t, []diagnostic.Diagnostic(diags), report.Diagnostics)
+}
@@ -0,0 +1,174 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package diagnostic
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pathSegmentRegex matches each of the segments of a path like `kubernetes.jobs[0].job_name_prefix`.
+var pathSegmentRegex = regexp.MustCompile(`([^.\[\]]+)|\[(\d+)\]`)
+
+// Locator resolves yaml paths to the position they have in the source document.
+type Locator struct {
+	root *yaml.Node
+	// files holds the file of each node when the document is merged from several files.
+	files map[*yaml.Node]string
+}
+
+// NewLocator parses the yaml data to build a Locator.
+func NewLocator(data []byte) (*Locator, error) {
+	root := &yaml.Node{}
+	if err := yaml.Unmarshal(data, root); err != nil {
+		return nil, fmt.Errorf("parsing yaml: %w", err)
+	}
+
+	return NewNodeLocator(root), nil
+}
+
+// NewNodeLocator returns a Locator for an already parsed yaml document.
+func NewNodeLocator(root *yaml.Node) *Locator {
+	return &Locator{root: root}
+}
+
+// NewMergedLocator returns a Locator for a document merged from several files, the files map holds the file each of
+// its nodes comes from.
+func NewMergedLocator(root *yaml.Node, files map[*yaml.Node]string) *Locator {
+	return &Locator{root: root, files: files}
+}
+
+// Locate returns the line and column of the element in the path. If the element does not exist, for instance
+// because a required field is missing, the position of the closest existing parent is returned instead.
+// Zero values are returned when the locator is nil or the document is empty.
+func (l *Locator) Locate(path string) (int, int) {
+	node := l.find(path)
+	if node == nil {
+		return 0, 0
+	}
+
+	return node.Line, node.Column
+}
+
+// File returns the file of the element in the path, or of its closest existing parent, as Locate does. An empty
+// string is returned when the locator was not built from several files.
+func (l *Locator) File(path string) string {
+	node := l.find(path)
+	if node == nil {
+		return ""
+	}
+
+	return l.files[node]
+}
+
+// FindKey returns the path and column of the mapping key at the line, like the keys reported by the yaml library when
+// decoding fails. Empty values are returned if it is not found.
+func (l *Locator) FindKey(line int, key string) (string, int) {
+	if l == nil || l.root == nil {
+		return "", 0
+	}
+
+	path, keyNode := findKey(l.root, "", line, key)
+	if keyNode == nil {
+		return "", 0
+	}
+
+	return path, keyNode.Column
+}
+
+func findKey(node *yaml.Node, path string, line int, key string) (string, *yaml.Node) {
+	switch node.Kind { //nolint: exhaustive
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			if found, keyNode := findKey(child, path, line, key); keyNode != nil {
+				return found, keyNode
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyPath := JoinPath(path, node.Content[i].Value)
+			if node.Content[i].Line == line && node.Content[i].Value == key {
+				return keyPath, node.Content[i]
+			}
+
+			if found, keyNode := findKey(node.Content[i+1], keyPath, line, key); keyNode != nil {
+				return found, keyNode
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			if found, keyNode := findKey(item, JoinPath(path, "["+strconv.Itoa(i)+"]"), line, key); keyNode != nil {
+				return found, keyNode
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// find returns the node holding the position of the element in the path: the key for mapping values, since it is the
+// position users expect to be pointed to, and the item itself for sequence items.
+func (l *Locator) find(path string) *yaml.Node {
+	if l == nil || l.root == nil {
+		return nil
+	}
+
+	node := l.root
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil
+		}
+		node = node.Content[0]
+	}
+
+	position := node
+
+	for _, segment := range pathSegmentRegex.FindAllStringSubmatch(path, -1) {
+		key, index := segment[1], segment[2]
+
+		var found *yaml.Node
+		if key != "" {
+			found, position = mappingValue(node, key, position)
+		} else {
+			found, position = sequenceItem(node, index, position)
+		}
+
+		if found == nil {
+			break
+		}
+
+		node = found
+	}
+
+	return position
+}
+
+// mappingValue returns the value for the key together with the key node, or the provided position if not found.
+func mappingValue(node *yaml.Node, key string, position *yaml.Node) (*yaml.Node, *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return nil, position
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1], node.Content[i]
+		}
+	}
+
+	return nil, position
+}
+
+func sequenceItem(node *yaml.Node, index string, position *yaml.Node) (*yaml.Node, *yaml.Node) {
+	i, err := strconv.Atoi(index)
+	if err != nil || node.Kind != yaml.SequenceNode || i >= len(node.Content) {
+		return nil, position
+	}
+
+	item := node.Content[i]
+
+	return item, item
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/diagnostic"
 	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
 	"github.com/newrelic/newrelic-prometheus-configurator/internal/scrapejob"
 	"github.com/newrelic/newrelic-prometheus-configurator/internal/sharding"
@@ -47,8 +48,8 @@ func (c Config) Build(shardingConfig sharding.Config) ([]promcfg.Job, error) {
 	var promScrapeJobs []promcfg.Job
 
 	for _, k8sJob := range c.K8sJobs {
-		if err := c.validate(k8sJob); err != nil {
-			return nil, err
+		if problems := c.validate(k8sJob); len(problems) > 0 {
+			return nil, problems[0].err
 		}
 
 		jrc, err := c.buildRelabelConfig(k8sJob)
@@ -71,12 +72,18 @@ func (c Config) Build(shardingConfig sharding.Config) ([]promcfg.Job, error) {
 }
 
 func buildPromJob(shardingConfig sharding.Config, k8sJob K8sJob, objPrefix string, relabelConfig []promcfg.RelabelConfig) promcfg.Job {
+	sdConfig := buildSdConfig(objPrefix, k8sJob.TargetDiscovery.AdditionalConfig)
+
+	if shardingConfig.NodeLocal() {
+		sdConfig, relabelConfig = nodeLocal(shardingConfig.NodeName, sdConfig, relabelConfig)
+	}
+
 	jobName := k8sJob.JobNamePrefix + "-" + objPrefix
 	promJob := k8sJob.ScrapeJob.
 		WithName(jobName).
 		WithRelabelConfigs(relabelConfig).
 		BuildPrometheusJob(shardingConfig)
-	promJob.KubernetesSdConfigs = append(promJob.KubernetesSdConfigs, buildSdConfig(objPrefix, k8sJob.TargetDiscovery.AdditionalConfig))
+	promJob.KubernetesSdConfigs = append(promJob.KubernetesSdConfigs, sdConfig)
 
 	return promJob
 }
@@ -163,20 +170,50 @@ func getConfigWithFallback(defaultConfig []string, config []string) ([]string, e
 	return nil, ErrIntegrationFilterConfig
 }
 
-func (c Config) validate(k8sJob K8sJob) error {
+// jobProblem is a problem found in a kubernetes job, along with the field of the job causing it.
+type jobProblem struct {
+	code  diagnostic.Code
+	field string
+	err   error
+}
+
+// validate returns all the problems found in the k8sJob, Build fails with the first one and Validate reports all.
+func (c Config) validate(k8sJob K8sJob) []jobProblem {
+	var problems []jobProblem
+
 	if !k8sJob.TargetDiscovery.Valid() {
-		return ErrInvalidK8sJobKinds
+		problems = append(problems, jobProblem{diagnostic.CodeInvalidK8sJobKinds, "target_discovery", ErrInvalidK8sJobKinds})
 	}
 
 	if k8sJob.JobNamePrefix == "" {
-		return ErrInvalidK8sJobPrefix
+		problems = append(problems, jobProblem{diagnostic.CodeInvalidK8sJobPrefix, "job_name_prefix", ErrInvalidK8sJobPrefix})
 	}
 
 	if k8sJob.ScrapeJob.SkipSharding {
-		return ErrInvalidSkipShardingFlag
+		problems = append(problems, jobProblem{diagnostic.CodeInvalidSkipSharding, "skip_sharding", ErrInvalidSkipShardingFlag})
+	}
+
+	if integrationFilterToBeApplied(c.IntegrationFilter, k8sJob.IntegrationFilter) {
+		if _, err := buildIntegrationFilter(c.IntegrationFilter, k8sJob.IntegrationFilter); err != nil {
+			problems = append(problems, jobProblem{diagnostic.CodeInvalidIntegrationFilter, "integrations_filter", err})
+		}
+	}
+
+	return problems
+}
+
+// Validate returns all the problems found in the kubernetes jobs. Unlike Build, it does not stop at the first one.
+// Paths are relative to the kubernetes config.
+func (c Config) Validate() diagnostic.List {
+	var diags diagnostic.List
+
+	for i, k8sJob := range c.K8sJobs {
+		for _, problem := range c.validate(k8sJob) {
+			diags = append(diags, diagnostic.Error(problem.code, fmt.Sprintf("jobs[%d].%s", i, problem.field), problem.err))
+		}
 	}
 
-	return nil
+	return diags
 }
 
 // K8sJob holds the configuration which will parsed to a prometheus scrape job including the
@@ -3,7 +3,9 @@ package kubernetes_test
 import (
 	"testing"
 
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/diagnostic"
 	"github.com/newrelic/newrelic-prometheus-configurator/internal/kubernetes"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
 	"github.com/newrelic/newrelic-prometheus-configurator/internal/scrapejob"
 	"github.com/newrelic/newrelic-prometheus-configurator/internal/sharding"
 	"github.com/stretchr/testify/require"
@@ -288,9 +290,8 @@ func TestBuildFilter(t *testing.T) { //nolint: funlen
 
 			expectedRegex := ""
 
-			// Order of source labels and regex is not guaranteed since they are created from
-			// a map in the filter.
-			// In order to test we also build an expected map and checks that all sourceLabels exist.
+			// The order of source labels and regex is checked in TestBuildFilterOrder.
+			// Here we build an expected map and check that all sourceLabels exist.
 			for i, actualSourceLabel := range actualRelabelConfig.SourceLabels {
 				val, ok := tt.want[actualSourceLabel]
 				require.True(t, ok, "source label not expected: ", actualSourceLabel)
@@ -310,6 +311,69 @@ func TestBuildFilter(t *testing.T) { //nolint: funlen
 	}
 }
 
+func TestBuildFilterOrder(t *testing.T) {
+	t.Parallel()
+
+	filter := kubernetes.Filter{
+		Annotations: map[string]string{"b": "2", "a": "1", "c": ""},
+		Labels:      map[string]string{"z": "26", "y": "25"},
+	}
+
+	// Conditions are sorted by key, annotations first, so the output does not depend on the map iteration order.
+	for range 20 {
+		relabelConfig := filter.Pod()
+		require.Equal(t, []string{
+			"__meta_kubernetes_pod_annotation_a",
+			"__meta_kubernetes_pod_annotation_b",
+			"__meta_kubernetes_pod_annotationpresent_c",
+			"__meta_kubernetes_pod_label_y",
+			"__meta_kubernetes_pod_label_z",
+		}, relabelConfig.SourceLabels)
+		require.Equal(t, "1;2;true;25;26", relabelConfig.Regex)
+	}
+}
+
+func TestBuildNodeLocal(t *testing.T) {
+	t.Parallel()
+
+	k8sConfig := kubernetes.Config{K8sJobs: []kubernetes.K8sJob{{
+		JobNamePrefix: "default",
+		TargetDiscovery: kubernetes.TargetDiscovery{
+			Pod:       true,
+			Endpoints: true,
+			AdditionalConfig: &kubernetes.AdditionalConfig{
+				Selectors: &[]promcfg.KubernetesSdSelector{
+					{Role: "pod", Field: "status.phase=Running"},
+					{Role: "service", Label: "app=api"},
+				},
+			},
+		},
+	}}}
+
+	jobs, err := k8sConfig.Build(sharding.Config{Kind: sharding.KindNode, NodeName: "ip-10-0-0-1.ec2.internal"})
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+
+	require.Equal(t, &[]promcfg.KubernetesSdSelector{
+		{Role: "pod", Field: "spec.nodeName=ip-10-0-0-1.ec2.internal,status.phase=Running"},
+		{Role: "service", Label: "app=api"},
+	}, jobs[0].KubernetesSdConfigs[0].Selectors)
+
+	// The selectors of the endpoints job do not filter the pods, so they are matched by the keep rule.
+	require.Equal(t, &[]promcfg.KubernetesSdSelector{
+		{Role: "pod", Field: "status.phase=Running"},
+		{Role: "service", Label: "app=api"},
+	}, jobs[1].KubernetesSdConfigs[0].Selectors)
+	require.Equal(t, promcfg.RelabelConfig{
+		SourceLabels: []string{"__meta_kubernetes_pod_node_name"},
+		Regex:        `ip-10-0-0-1\.ec2\.internal`,
+		Action:       "keep",
+	}, jobs[1].RelabelConfigs[0])
+
+	// The additional config is not modified.
+	require.Equal(t, "status.phase=Running", (*k8sConfig.K8sJobs[0].TargetDiscovery.AdditionalConfig.Selectors)[0].Field)
+}
+
 func TestBuildIntegrationFilter(t *testing.T) { //nolint: funlen
 	t.Parallel()
 
@@ -562,3 +626,38 @@ func TestBuildIntegrationFilterDifferentConfig(t *testing.T) {
 func boolPtr(b bool) *bool {
 	return &b
 }
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	t.Parallel()
+
+	k8sConfig := kubernetes.Config{
+		K8sJobs: []kubernetes.K8sJob{
+			{JobNamePrefix: "valid", TargetDiscovery: kubernetes.TargetDiscovery{Pod: true}},
+			{
+				ScrapeJob:         scrapejob.Job{SkipSharding: true},
+				IntegrationFilter: kubernetes.IntegrationFilter{Enabled: boolPtr(true)},
+			},
+		},
+	}
+
+	type problem struct {
+		Code diagnostic.Code
+		Path string
+	}
+
+	var actual []problem
+	for _, d := range k8sConfig.Validate() {
+		actual = append(actual, problem{Code: d.Code, Path: d.Path})
+	}
+
+	require.Equal(t, []problem{
+		{Code: diagnostic.CodeInvalidK8sJobKinds, Path: "jobs[1].target_discovery"},
+		{Code: diagnostic.CodeInvalidK8sJobPrefix, Path: "jobs[1].job_name_prefix"},
+		{Code: diagnostic.CodeInvalidSkipSharding, Path: "jobs[1].skip_sharding"},
+		{Code: diagnostic.CodeInvalidIntegrationFilter, Path: "jobs[1].integrations_filter"},
+	}, actual)
+
+	// Build fails with the first of them.
+	_, err := k8sConfig.Build(sharding.Config{})
+	require.ErrorIs(t, err, kubernetes.ErrInvalidK8sJobKinds)
+}
@@ -1,7 +1,9 @@
 package kubernetes
 
 import (
+	"maps"
 	"regexp"
+	"slices"
 
 	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
 )
@@ -61,8 +63,11 @@ func (f Filter) build(metadataSourcePrefix string) promcfg.RelabelConfig {
 
 // addConditions iterates over the metadata and appends the conditions
 // to `source_labels` and `regex` of the filter.
+// Conditions are added sorted by key, so the same filter always renders the same rule.
 func addConditions(relabelConfig *promcfg.RelabelConfig, metadata map[string]string, metadataPrefix string) {
-	for prometheusLabels, regex := range metadata {
+	for _, prometheusLabels := range slices.Sorted(maps.Keys(metadata)) {
+		regex := metadata[prometheusLabels]
+
 		// Prometheus sanitize all metadata keys (like kubernetes label/annotations names) to comply
 		// with their naming conventions. We have to do the same so we can match in relabel configs.
 		// The values in the metadata are not sanitized.
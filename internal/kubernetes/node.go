@@ -0,0 +1,55 @@
+package kubernetes
+
+import (
+	"regexp"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+)
+
+const podNodeNameMetadata = podMetadata + "_node_name"
+
+// nodeLocal limits the job to the targets of the node. Pods are selected by the API server with a field selector, so
+// the ones of other nodes are not even watched, while endpoints are kept when their backing pod runs in the node.
+func nodeLocal(nodeName string, sdConfig promcfg.KubernetesSdConfig, relabelConfig []promcfg.RelabelConfig) (promcfg.KubernetesSdConfig, []promcfg.RelabelConfig) {
+	if sdConfig.Role == endpointsKind {
+		keepNode := promcfg.RelabelConfig{
+			SourceLabels: []string{podNodeNameMetadata},
+			Regex:        regexp.QuoteMeta(nodeName),
+			Action:       "keep",
+		}
+
+		return sdConfig, append([]promcfg.RelabelConfig{keepNode}, relabelConfig...)
+	}
+
+	nodeField := "spec.nodeName=" + nodeName
+
+	var selectors []promcfg.KubernetesSdSelector
+	if sdConfig.Selectors != nil {
+		selectors = append(selectors, *sdConfig.Selectors...)
+	}
+
+	// Prometheus accepts a single selector by role, so the field is added to the pod one if there is any.
+	found := false
+
+	for i := range selectors {
+		if selectors[i].Role != podKind {
+			continue
+		}
+
+		found = true
+
+		if selectors[i].Field == "" {
+			selectors[i].Field = nodeField
+		} else {
+			selectors[i].Field = nodeField + "," + selectors[i].Field
+		}
+	}
+
+	if !found {
+		selectors = append(selectors, promcfg.KubernetesSdSelector{Role: podKind, Field: nodeField})
+	}
+
+	sdConfig.Selectors = &selectors
+
+	return sdConfig, relabelConfig
+}
@@ -23,27 +23,27 @@ type TLSConfig struct {
 // Authorization holds prometheus authorization information.
 type Authorization struct {
 	Type            string `yaml:"type,omitempty"`
-	Credentials     string `yaml:"credentials,omitempty"`
+	Credentials     string `yaml:"credentials,omitempty" secret:"true"`
 	CredentialsFile string `yaml:"credentials_file,omitempty"`
 }
 
 // BasicAuth defines the config for the `Authorization` header on every scrape request.
 type BasicAuth struct {
 	Username     string `yaml:"username"`
-	Password     string `yaml:"password,omitempty"`
+	Password     string `yaml:"password,omitempty" secret:"true"`
 	PasswordFile string `yaml:"password_file,omitempty"`
 }
 
 // OAuth2 defines the config for prometheus to gather a token from the endpoint.
 type OAuth2 struct {
 	ClientID             string            `yaml:"client_id"`
-	ClientSecret         string            `yaml:"client_secret,omitempty"`
+	ClientSecret         string            `yaml:"client_secret,omitempty" secret:"true"`
 	ClientSecretFile     string            `yaml:"client_secret_file,omitempty"`
 	Scopes               []string          `yaml:"scopes,omitempty"`
-	TokenURL             string            `yaml:"token_url"`
+	TokenURL             string            `yaml:"token_url" secret:"url"`
 	EndpointParams       map[string]string `yaml:"endpoint_params,omitempty"`
 	TLSConfig            *TLSConfig        `yaml:"tls_config,omitempty"`
-	ProxyURL             string            `yaml:"proxy_url,omitempty"`
+	ProxyURL             string            `yaml:"proxy_url,omitempty" secret:"url"`
 	ProxyFromEnvironment bool              `yaml:"proxy_from_environment,omitempty"`
 }
 
@@ -68,7 +68,7 @@ type Job struct {
 	BasicAuth              *BasicAuth       `yaml:"basic_auth,omitempty"`
 	Authorization          Authorization    `yaml:"authorization,omitempty"`
 	OAuth2                 OAuth2           `yaml:"oauth2,omitempty"`
-	ProxyURL               string           `yaml:"proxy_url,omitempty"`
+	ProxyURL               string           `yaml:"proxy_url,omitempty" secret:"url"`
 	ProxyFromEnvironment   bool             `yaml:"proxy_from_environment,omitempty"`
 
 	StaticConfigs        []StaticConfig       `yaml:"static_configs,omitempty"`
@@ -160,28 +160,40 @@ type KubernetesSdSelector struct {
 	Field string `yaml:"field,omitempty"`
 }
 
-// QueueConfig represents the remote-write queue config.
+// QueueConfig represents the remote-write queue config, the fields not set keep the Prometheus defaults.
 type QueueConfig struct {
-	Capacity          int           `yaml:"capacity"`
-	MaxShards         int           `yaml:"max_shards"`
-	MinShards         int           `yaml:"min_shards"`
-	MaxSamplesPerSend int           `yaml:"max_samples_per_send"`
-	BatchSendDeadLine time.Duration `yaml:"batch_send_deadline"`
-	MinBackoff        time.Duration `yaml:"min_backoff"`
-	MaxBackoff        time.Duration `yaml:"max_backoff"`
-	RetryOnHTTP429    *bool         `yaml:"retry_on_http_429"`
-	SampleAgeLimit    time.Duration `yaml:"sample_age_limit"`
+	Capacity          int           `yaml:"capacity,omitempty"`
+	MaxShards         int           `yaml:"max_shards,omitempty"`
+	MinShards         int           `yaml:"min_shards,omitempty"`
+	MaxSamplesPerSend int           `yaml:"max_samples_per_send,omitempty"`
+	BatchSendDeadLine time.Duration `yaml:"batch_send_deadline,omitempty"`
+	MinBackoff        time.Duration `yaml:"min_backoff,omitempty"`
+	MaxBackoff        time.Duration `yaml:"max_backoff,omitempty"`
+	RetryOnHTTP429    *bool         `yaml:"retry_on_http_429,omitempty"`
+	SampleAgeLimit    time.Duration `yaml:"sample_age_limit,omitempty"`
 }
 
 // RemoteWrite represents a prometheus remote_write config.
 type RemoteWrite struct {
 	Name                 string          `yaml:"name"`
-	URL                  string          `yaml:"url"`
+	URL                  string          `yaml:"url" secret:"url"`
 	RemoteTimeout        time.Duration   `yaml:"remote_timeout,omitempty"`
 	Authorization        Authorization   `yaml:"authorization"`
 	TLSConfig            *TLSConfig      `yaml:"tls_config,omitempty"`
-	ProxyURL             string          `yaml:"proxy_url,omitempty"`
+	ProxyURL             string          `yaml:"proxy_url,omitempty" secret:"url"`
 	ProxyFromEnvironment bool            `yaml:"proxy_from_environment,omitempty"`
 	QueueConfig          *QueueConfig    `yaml:"queue_config,omitempty"`
+	MetadataConfig       *MetadataConfig `yaml:"metadata_config,omitempty"`
 	WriteRelabelConfigs  []RelabelConfig `yaml:"write_relabel_configs,omitempty"`
+	SendExemplars        bool            `yaml:"send_exemplars,omitempty"`
+	SendNativeHistograms bool            `yaml:"send_native_histograms,omitempty"`
+	// Headers are sent along with every request, their values may hold tokens so all of them are masked.
+	Headers map[string]string `yaml:"headers,omitempty" secret:"values"`
+}
+
+// MetadataConfig represents the remote-write metadata config, the fields not set keep the Prometheus defaults.
+type MetadataConfig struct {
+	Send              *bool         `yaml:"send,omitempty"`
+	SendInterval      time.Duration `yaml:"send_interval,omitempty"`
+	MaxSamplesPerSend int           `yaml:"max_samples_per_send,omitempty"`
 }
@@ -0,0 +1,407 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package relabeling runs the relabel rules of a generated Prometheus config with the Prometheus relabel engine, so
+// the effect of each rule on a set of labels can be inspected.
+package relabeling
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/sharding"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultScheme      = "http"
+	defaultMetricsPath = "/metrics"
+)
+
+var (
+	ErrJobNotFound         = errors.New("scrape job not found")
+	ErrRemoteWriteNotFound = errors.New("remote write not found")
+	ErrNoShardOwner        = errors.New("no shard keeps the target")
+	ErrInvalidLabel        = errors.New("labels must be set as name=value")
+)
+
+// Config holds the parts of a Prometheus config involving relabeling.
+type Config struct {
+	ScrapeConfigs []ScrapeConfig `yaml:"scrape_configs"`
+	RemoteWrite   []RemoteWrite  `yaml:"remote_write"`
+}
+
+// ScrapeConfig holds the relabel rules of a scrape job.
+type ScrapeConfig struct {
+	JobName              string            `yaml:"job_name"`
+	Scheme               string            `yaml:"scheme"`
+	MetricsPath          string            `yaml:"metrics_path"`
+	Params               url.Values        `yaml:"params"`
+	RelabelConfigs       []*relabel.Config `yaml:"relabel_configs"`
+	MetricRelabelConfigs []*relabel.Config `yaml:"metric_relabel_configs"`
+}
+
+// RemoteWrite holds the relabel rules of a remote write.
+type RemoteWrite struct {
+	Name                string            `yaml:"name"`
+	WriteRelabelConfigs []*relabel.Config `yaml:"write_relabel_configs"`
+}
+
+// Load parses the Prometheus config yaml. Rules are loaded and validated as Prometheus does, so defaults like the
+// `(.*)` regex are applied.
+func Load(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("parsing prometheus config: %w", err)
+	}
+
+	for _, sc := range config.ScrapeConfigs {
+		if err := validate(sc.RelabelConfigs); err != nil {
+			return nil, fmt.Errorf("job %q relabel_configs: %w", sc.JobName, err)
+		}
+
+		if err := validate(sc.MetricRelabelConfigs); err != nil {
+			return nil, fmt.Errorf("job %q metric_relabel_configs: %w", sc.JobName, err)
+		}
+	}
+
+	for _, rw := range config.RemoteWrite {
+		if err := validate(rw.WriteRelabelConfigs); err != nil {
+			return nil, fmt.Errorf("remote write %q write_relabel_configs: %w", rw.Name, err)
+		}
+	}
+
+	return config, nil
+}
+
+// ScrapeConfig returns the scrape job with the provided name.
+func (c *Config) ScrapeConfig(jobName string) (*ScrapeConfig, error) {
+	for i := range c.ScrapeConfigs {
+		if c.ScrapeConfigs[i].JobName == jobName {
+			return &c.ScrapeConfigs[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrJobNotFound, jobName)
+}
+
+// JobNames returns the names of all the scrape jobs.
+func (c *Config) JobNames() []string {
+	names := make([]string, 0, len(c.ScrapeConfigs))
+	for _, sc := range c.ScrapeConfigs {
+		names = append(names, sc.JobName)
+	}
+
+	return names
+}
+
+// RemoteWriteConfig returns the remote write with the provided name.
+func (c *Config) RemoteWriteConfig(name string) (*RemoteWrite, error) {
+	for i := range c.RemoteWrite {
+		if c.RemoteWrite[i].Name == name {
+			return &c.RemoteWrite[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrRemoteWriteNotFound, name)
+}
+
+// TargetLabels adds to the discovered labels the ones Prometheus sets from the job before relabeling, unless they
+// are already present.
+func (sc *ScrapeConfig) TargetLabels(discovered labels.Labels) labels.Labels {
+	scheme := sc.Scheme
+	if scheme == "" {
+		scheme = defaultScheme
+	}
+
+	metricsPath := sc.MetricsPath
+	if metricsPath == "" {
+		metricsPath = defaultMetricsPath
+	}
+
+	defaults := map[string]string{
+		model.JobLabel:         sc.JobName,
+		model.SchemeLabel:      scheme,
+		model.MetricsPathLabel: metricsPath,
+	}
+
+	for name, values := range sc.Params {
+		if len(values) > 0 {
+			defaults[model.ParamLabelPrefix+name] = values[0]
+		}
+	}
+
+	lb := labels.NewBuilder(discovered)
+
+	for name, value := range defaults {
+		if !discovered.Has(name) {
+			lb.Set(name, value)
+		}
+	}
+
+	return lb.Labels()
+}
+
+// FromPromcfg converts rules built by the configurator into Prometheus rules.
+func FromPromcfg(rules []promcfg.RelabelConfig) ([]*relabel.Config, error) {
+	data, err := yaml.Marshal(rules)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling relabel configs: %w", err)
+	}
+
+	var converted []*relabel.Config
+	if err := yaml.Unmarshal(data, &converted); err != nil {
+		return nil, fmt.Errorf("parsing relabel configs: %w", err)
+	}
+
+	if err := validate(converted); err != nil {
+		return nil, err
+	}
+
+	return converted, nil
+}
+
+func validate(rules []*relabel.Config) error {
+	for i, rule := range rules {
+		if err := rule.Validate(model.UTF8Validation); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// Step is the result of applying a single rule.
+type Step struct {
+	Rule   *relabel.Config
+	Before labels.Labels
+	// After holds the labels once the rule is applied, it is empty if the rule dropped them.
+	After labels.Labels
+	Kept  bool
+}
+
+// Changed returns true if the rule modified the labels.
+func (s Step) Changed() bool {
+	return s.Kept && !labels.Equal(s.Before, s.After)
+}
+
+// Diff describes the changes done by the rule, one line per label added (`+`), removed (`-`) or modified (`~`).
+func (s Step) Diff() []string {
+	if !s.Kept {
+		return nil
+	}
+
+	var diff []string
+
+	s.Before.Range(func(l labels.Label) {
+		switch after := s.After.Get(l.Name); {
+		case !s.After.Has(l.Name):
+			diff = append(diff, fmt.Sprintf("- %s=%q", l.Name, l.Value))
+		case after != l.Value:
+			diff = append(diff, fmt.Sprintf("~ %s=%q -> %q", l.Name, l.Value, after))
+		}
+	})
+
+	s.After.Range(func(l labels.Label) {
+		if !s.Before.Has(l.Name) {
+			diff = append(diff, fmt.Sprintf("+ %s=%q", l.Name, l.Value))
+		}
+	})
+
+	return diff
+}
+
+// Result holds every step applied to the labels, the processing stops at the first rule dropping them.
+type Result struct {
+	Steps  []Step
+	Labels labels.Labels
+	Kept   bool
+}
+
+// DroppedBy returns the 1-based position of the rule dropping the labels, or 0 if they were kept.
+func (r Result) DroppedBy() int {
+	if r.Kept {
+		return 0
+	}
+
+	return len(r.Steps)
+}
+
+// Process applies the rules one by one recording the labels before and after each of them.
+func Process(rules []*relabel.Config, lbls labels.Labels) Result {
+	result := Result{Kept: true, Labels: lbls}
+	lb := labels.NewBuilder(lbls)
+
+	for _, rule := range rules {
+		before := lb.Labels()
+		kept := relabel.ProcessBuilder(lb, rule)
+
+		step := Step{Rule: rule, Before: before, Kept: kept}
+		if kept {
+			step.After = lb.Labels()
+		}
+
+		result.Steps = append(result.Steps, step)
+
+		if !kept {
+			result.Kept = false
+			result.Labels = labels.EmptyLabels()
+
+			return result
+		}
+	}
+
+	result.Labels = lb.Labels()
+
+	return result
+}
+
+// ScrapedLabels returns the labels Prometheus attaches to the scraped series of a target once relabeled: labels
+// starting with `__` are removed and `instance` defaults to the target address.
+func ScrapedLabels(relabeled labels.Labels) labels.Labels {
+	lb := labels.NewBuilder(relabeled)
+
+	if !relabeled.Has(model.InstanceLabel) {
+		lb.Set(model.InstanceLabel, relabeled.Get(model.AddressLabel))
+	}
+
+	relabeled.Range(func(l labels.Label) {
+		if strings.HasPrefix(l.Name, model.ReservedLabelPrefix) {
+			lb.Del(l.Name)
+		}
+	})
+
+	return lb.Labels()
+}
+
+// ScrapeURL returns the URL Prometheus scrapes for a target once relabeled.
+func ScrapeURL(relabeled labels.Labels) string {
+	params := url.Values{}
+
+	relabeled.Range(func(l labels.Label) {
+		if name, found := strings.CutPrefix(l.Name, model.ParamLabelPrefix); found {
+			params.Set(name, l.Value)
+		}
+	})
+
+	scrapeURL := url.URL{
+		Scheme:   relabeled.Get(model.SchemeLabel),
+		Host:     relabeled.Get(model.AddressLabel),
+		Path:     relabeled.Get(model.MetricsPathLabel),
+		RawQuery: params.Encode(),
+	}
+
+	return scrapeURL.String()
+}
+
+// ShardingRules returns the sharding rules for the provided shard.
+func ShardingRules(shardingConfig sharding.Config, shard int) ([]*relabel.Config, error) {
+	shardingConfig.ShardIndex = strconv.Itoa(shard)
+
+	rules, err := FromPromcfg(shardingConfig.RelabelConfigs())
+	if err != nil {
+		return nil, fmt.Errorf("building sharding rules: %w", err)
+	}
+
+	return rules, nil
+}
+
+// ShardOwner returns the index of the shard which keeps the target having the provided labels.
+func ShardOwner(shardingConfig sharding.Config, lbls labels.Labels) (int, error) {
+	for i := range shardingConfig.TotalShardsCount {
+		rules, err := ShardingRules(shardingConfig, i)
+		if err != nil {
+			return 0, err
+		}
+
+		if Process(rules, lbls).Kept {
+			return i, nil
+		}
+	}
+
+	return 0, ErrNoShardOwner
+}
+
+// SplitShardingRules returns the rules following the sharding ones, and whether the rules start with the sharding
+// rules of the provided config. Jobs skipping sharding do not include them.
+func SplitShardingRules(rules []*relabel.Config, shardingConfig sharding.Config) ([]*relabel.Config, bool, error) {
+	if !shardingConfig.ShouldIncludeShardingRules() {
+		return rules, false, nil
+	}
+
+	shardingRules, err := FromPromcfg(shardingConfig.RelabelConfigs())
+	if err != nil {
+		return nil, false, fmt.Errorf("building sharding rules: %w", err)
+	}
+
+	if len(rules) < len(shardingRules) {
+		return rules, false, nil
+	}
+
+	for i := range shardingRules {
+		if FormatRule(rules[i]) != FormatRule(shardingRules[i]) {
+			return rules, false, nil
+		}
+	}
+
+	return rules[len(shardingRules):], true, nil
+}
+
+// ParseLabels parses labels in the `name=value,name=value` format.
+func ParseLabels(s string) (labels.Labels, error) {
+	lb := labels.NewBuilder(labels.EmptyLabels())
+
+	for pair := range strings.SplitSeq(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, value, found := strings.Cut(pair, "=")
+		if !found || name == "" {
+			return labels.EmptyLabels(), fmt.Errorf("%w: %q", ErrInvalidLabel, pair)
+		}
+
+		lb.Set(strings.TrimSpace(name), strings.Trim(strings.TrimSpace(value), `"`))
+	}
+
+	return lb.Labels(), nil
+}
+
+// FormatRule returns a compact description of the rule including only the fields relevant to its action.
+func FormatRule(rule *relabel.Config) string {
+	fields := []string{"action=" + string(rule.Action)}
+
+	if len(rule.SourceLabels) > 0 {
+		fields = append(fields, fmt.Sprintf("source_labels=[%s]", rule.SourceLabels))
+	}
+
+	if rule.Separator != relabel.DefaultRelabelConfig.Separator {
+		fields = append(fields, fmt.Sprintf("separator=%q", rule.Separator))
+	}
+
+	switch rule.Action { //nolint: exhaustive
+	case relabel.KeepEqual, relabel.DropEqual, relabel.Lowercase, relabel.Uppercase:
+	case relabel.HashMod:
+		fields = append(fields, fmt.Sprintf("modulus=%d", rule.Modulus))
+	default:
+		fields = append(fields, fmt.Sprintf("regex=%q", rule.Regex.String()))
+	}
+
+	if rule.TargetLabel != "" {
+		fields = append(fields, "target_label="+rule.TargetLabel)
+	}
+
+	if rule.Action == relabel.Replace || rule.Action == relabel.LabelMap {
+		fields = append(fields, fmt.Sprintf("replacement=%q", rule.Replacement))
+	}
+
+	return strings.Join(fields, " ")
+}
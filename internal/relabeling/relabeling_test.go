@@ -0,0 +1,353 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package relabeling_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/relabeling"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/sharding"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const promConfig = `
+scrape_configs:
+- job_name: pods
+  scheme: https
+  relabel_configs:
+  - source_labels: [__meta_kubernetes_pod_annotation_prometheus_io_scrape]
+    regex: "true"
+    action: keep
+  - source_labels: [__meta_kubernetes_namespace]
+    target_label: namespace
+  - regex: __meta_kubernetes_pod_label_(.+)
+    action: labelmap
+  metric_relabel_configs:
+  - source_labels: [__name__]
+    regex: go_.*
+    action: drop
+remote_write:
+- name: newrelic_rw
+  url: https://metric-api.newrelic.com/prometheus/v1/write
+`
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	config, err := relabeling.Load([]byte(promConfig))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"pods"}, config.JobNames())
+
+	job, err := config.ScrapeConfig("pods")
+	require.NoError(t, err)
+	require.Len(t, job.RelabelConfigs, 3)
+	// Prometheus defaults are applied to the fields not set.
+	assert.Equal(t, "action=replace source_labels=[__meta_kubernetes_namespace] regex=\"(.*)\" target_label=namespace replacement=\"$1\"",
+		relabeling.FormatRule(job.RelabelConfigs[1]))
+	require.Len(t, job.MetricRelabelConfigs, 1)
+
+	_, err = config.ScrapeConfig("missing")
+	require.ErrorIs(t, err, relabeling.ErrJobNotFound)
+
+	rw, err := config.RemoteWriteConfig("newrelic_rw")
+	require.NoError(t, err)
+	assert.Empty(t, rw.WriteRelabelConfigs)
+}
+
+func TestLoadInvalidRule(t *testing.T) {
+	t.Parallel()
+
+	_, err := relabeling.Load([]byte(`
+scrape_configs:
+- job_name: broken
+  relabel_configs:
+  - action: hashmod
+    source_labels: [__address__]
+    target_label: __tmp_hash
+`))
+	require.ErrorContains(t, err, `job "broken" relabel_configs: rule 1`)
+}
+
+func TestProcess(t *testing.T) {
+	t.Parallel()
+
+	config, err := relabeling.Load([]byte(promConfig))
+	require.NoError(t, err)
+
+	job, err := config.ScrapeConfig("pods")
+	require.NoError(t, err)
+
+	t.Run("Kept", func(t *testing.T) {
+		t.Parallel()
+
+		input := job.TargetLabels(labels.FromStrings(
+			"__address__", "10.0.0.1:8080",
+			"__meta_kubernetes_pod_annotation_prometheus_io_scrape", "true",
+			"__meta_kubernetes_namespace", "default",
+			"__meta_kubernetes_pod_label_app", "redis",
+		))
+		assert.Equal(t, "https", input.Get("__scheme__"))
+		assert.Equal(t, "/metrics", input.Get("__metrics_path__"))
+		assert.Equal(t, "pods", input.Get("job"))
+
+		result := relabeling.Process(job.RelabelConfigs, input)
+		require.True(t, result.Kept)
+		assert.Zero(t, result.DroppedBy())
+		require.Len(t, result.Steps, 3)
+
+		assert.False(t, result.Steps[0].Changed())
+		assert.Equal(t, []string{`+ namespace="default"`}, result.Steps[1].Diff())
+		assert.Equal(t, []string{`+ app="redis"`}, result.Steps[2].Diff())
+
+		expected := labels.FromStrings("app", "redis", "instance", "10.0.0.1:8080", "job", "pods", "namespace", "default")
+		assert.Equal(t, expected, relabeling.ScrapedLabels(result.Labels))
+	})
+
+	t.Run("Dropped", func(t *testing.T) {
+		t.Parallel()
+
+		input := labels.FromStrings("__address__", "10.0.0.1:8080")
+
+		result := relabeling.Process(job.RelabelConfigs, input)
+		require.False(t, result.Kept)
+		assert.Equal(t, 1, result.DroppedBy())
+		assert.Len(t, result.Steps, 1)
+		assert.Empty(t, result.Steps[0].After)
+	})
+}
+
+func TestShardOwner(t *testing.T) {
+	t.Parallel()
+
+	shardingConfig := sharding.Config{TotalShardsCount: 3}
+
+	for i := range 20 {
+		target := labels.FromStrings("__address__", fmt.Sprintf("10.0.0.%d:8080", i))
+
+		owner, err := relabeling.ShardOwner(shardingConfig, target)
+		require.NoError(t, err)
+
+		// Only the owner keeps the target.
+		for shard := range shardingConfig.TotalShardsCount {
+			shardingConfig.ShardIndex = fmt.Sprint(shard)
+			rules, err := relabeling.FromPromcfg(shardingConfig.RelabelConfigs())
+			require.NoError(t, err)
+
+			assert.Equal(t, shard == owner, relabeling.Process(rules, target).Kept)
+		}
+	}
+}
+
+func TestShardingHashSource(t *testing.T) {
+	t.Parallel()
+
+	rules, err := relabeling.FromPromcfg(sharding.Config{TotalShardsCount: 2}.RelabelConfigs()[:1])
+	require.NoError(t, err)
+
+	for address, expected := range map[string]string{
+		"10.0.0.1:8080":             "10.0.0.1",
+		"10.0.0.1":                  "10.0.0.1",
+		"[2001:db8::1]:9090":        "2001:db8::1",
+		"[2001:db8::1]":             "2001:db8::1",
+		"2001:db8::1":               "2001:db8::1",
+		"node-1.example.com:9100":   "node-1.example.com",
+		"node-1.example.com":        "node-1.example.com",
+		"kube-dns.kube-system:9153": "kube-dns.kube-system",
+	} {
+		result := relabeling.Process(rules, labels.FromStrings("__address__", address))
+		assert.Equal(t, expected, result.Labels.Get("__tmp_hash"), address)
+	}
+}
+
+func TestShardOwnerDistribution(t *testing.T) {
+	t.Parallel()
+
+	const (
+		shards  = 3
+		targets = 300
+	)
+
+	families := map[string]func(i int) string{
+		"ipv4":     func(i int) string { return fmt.Sprintf("10.0.%d.%d:8080", i/256, i%256) },
+		"ipv6":     func(i int) string { return fmt.Sprintf("[2001:db8::%x]:8080", i) },
+		"hostname": func(i int) string { return fmt.Sprintf("node-%d.example.com:9100", i) },
+	}
+
+	for name, address := range families {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			shardingConfig := sharding.Config{TotalShardsCount: shards}
+			owned := make([]int, shards)
+
+			for i := range targets {
+				owner, err := relabeling.ShardOwner(shardingConfig, labels.FromStrings("__address__", address(i)))
+				require.NoError(t, err)
+
+				owned[owner]++
+			}
+
+			// Each shard owns roughly a third of the targets.
+			for shard, count := range owned {
+				assert.InDelta(t, targets/shards, count, targets/shards/4, "shard %d owns %d targets", shard, count)
+			}
+		})
+	}
+}
+
+func TestShardOwnerIgnoresPort(t *testing.T) {
+	t.Parallel()
+
+	shardingConfig := sharding.Config{TotalShardsCount: 5}
+
+	for _, addresses := range [][]string{
+		{"10.0.0.1:8080", "10.0.0.1:9090", "10.0.0.1"},
+		{"[2001:db8::1]:8080", "[2001:db8::1]:9090", "[2001:db8::1]"},
+		{"node-1.example.com:8080", "node-1.example.com:9090", "node-1.example.com"},
+	} {
+		owners := map[int]bool{}
+
+		for _, address := range addresses {
+			owner, err := relabeling.ShardOwner(shardingConfig, labels.FromStrings("__address__", address))
+			require.NoError(t, err)
+
+			owners[owner] = true
+		}
+
+		assert.Len(t, owners, 1, "the ports of %v are sharded apart", addresses)
+	}
+}
+
+func TestShardOwnerHashSourceLabels(t *testing.T) {
+	t.Parallel()
+
+	shardingConfig := sharding.Config{TotalShardsCount: 5, HashSourceLabels: []string{"namespace", "pod"}}
+
+	first, err := relabeling.ShardOwner(shardingConfig, labels.FromStrings("__address__", "10.0.0.1:8080", "namespace", "default", "pod", "api"))
+	require.NoError(t, err)
+
+	second, err := relabeling.ShardOwner(shardingConfig, labels.FromStrings("__address__", "10.0.0.2:8080", "namespace", "default", "pod", "api"))
+	require.NoError(t, err)
+
+	assert.Equal(t, first, second, "the address is not hashed")
+
+	owners := map[int]bool{}
+
+	for i := range 50 {
+		owner, err := relabeling.ShardOwner(shardingConfig, labels.FromStrings("namespace", "default", "pod", fmt.Sprintf("api-%d", i)))
+		require.NoError(t, err)
+
+		owners[owner] = true
+	}
+
+	assert.Len(t, owners, shardingConfig.TotalShardsCount)
+}
+
+func TestShardOwnerNamespace(t *testing.T) {
+	t.Parallel()
+
+	shardingConfig := sharding.Config{
+		Kind:             sharding.KindNamespace,
+		TotalShardsCount: 4,
+		PinnedNamespaces: map[string]int{"tenant-a": 3, "tenant.b": 3, "tenant-c": 0},
+	}
+
+	owner := func(lbls ...string) int {
+		t.Helper()
+
+		shard, err := relabeling.ShardOwner(shardingConfig, labels.FromStrings(lbls...))
+		require.NoError(t, err)
+
+		return shard
+	}
+
+	// All the targets of a namespace are scraped by the same shard.
+	for i := range 20 {
+		namespace := fmt.Sprintf("namespace-%d", i)
+		assert.Equal(t,
+			owner("__address__", "10.0.0.1:8080", "__meta_kubernetes_namespace", namespace),
+			owner("__address__", fmt.Sprintf("10.0.1.%d:9090", i), "__meta_kubernetes_namespace", namespace),
+		)
+	}
+
+	assert.Equal(t, 3, owner("__address__", "10.0.0.1:8080", "__meta_kubernetes_namespace", "tenant-a"))
+	assert.Equal(t, 3, owner("__address__", "10.0.0.2:8080", "__meta_kubernetes_namespace", "tenant.b"))
+	assert.Equal(t, 0, owner("__address__", "10.0.0.3:8080", "__meta_kubernetes_namespace", "tenant-c"))
+
+	// The dot of the pinned namespace is not a wildcard, so the similar namespaces are hashed to any shard.
+	similarOwners := map[int]bool{}
+	for c := 'a'; c <= 'z'; c++ {
+		similarOwners[owner("__address__", "10.0.0.1:8080", "__meta_kubernetes_namespace", fmt.Sprintf("tenant%cb", c))] = true
+	}
+
+	assert.Len(t, similarOwners, shardingConfig.TotalShardsCount)
+
+	// Targets without namespace, like the static ones, are sharded by their address.
+	owners := map[int]bool{}
+
+	for i := range 50 {
+		owners[owner("__address__", fmt.Sprintf("node-%d.example.com:9100", i))] = true
+	}
+
+	assert.Len(t, owners, shardingConfig.TotalShardsCount)
+}
+
+func TestFromPromcfg(t *testing.T) {
+	t.Parallel()
+
+	rules, err := relabeling.FromPromcfg([]promcfg.RelabelConfig{
+		{SourceLabels: []string{"__address__"}, TargetLabel: "address"},
+	})
+	require.NoError(t, err)
+
+	result := relabeling.Process(rules, labels.FromStrings("__address__", "host:80"))
+	assert.Equal(t, "host:80", result.Labels.Get("address"))
+}
+
+func TestParseLabels(t *testing.T) {
+	t.Parallel()
+
+	lbls, err := relabeling.ParseLabels(`__address__=10.0.0.1:80, app="redis",`)
+	require.NoError(t, err)
+	assert.Equal(t, labels.FromStrings("__address__", "10.0.0.1:80", "app", "redis"), lbls)
+
+	_, err = relabeling.ParseLabels("app")
+	require.ErrorIs(t, err, relabeling.ErrInvalidLabel)
+}
+
+func TestScrapeURL(t *testing.T) {
+	t.Parallel()
+
+	job := relabeling.ScrapeConfig{JobName: "job", Params: map[string][]string{"module": {"http_2xx"}}}
+	lbls := job.TargetLabels(labels.FromStrings("__address__", "10.0.0.1:9115", "__param_target", "example.com"))
+
+	assert.Equal(t, "http://10.0.0.1:9115/metrics?module=http_2xx&target=example.com", relabeling.ScrapeURL(lbls))
+}
+
+func TestSplitShardingRules(t *testing.T) {
+	t.Parallel()
+
+	shardingConfig := sharding.Config{TotalShardsCount: 2, ShardIndex: "1"}
+
+	shardingRules, err := relabeling.FromPromcfg(shardingConfig.RelabelConfigs())
+	require.NoError(t, err)
+
+	jobRules, err := relabeling.FromPromcfg([]promcfg.RelabelConfig{{SourceLabels: []string{"a"}, TargetLabel: "b"}})
+	require.NoError(t, err)
+
+	rules, sharded, err := relabeling.SplitShardingRules(append(shardingRules, jobRules...), shardingConfig)
+	require.NoError(t, err)
+	assert.True(t, sharded)
+	assert.Equal(t, jobRules, rules)
+
+	rules, sharded, err = relabeling.SplitShardingRules(jobRules, shardingConfig)
+	require.NoError(t, err)
+	assert.False(t, sharded)
+	assert.Equal(t, jobRules, rules)
+}
@@ -0,0 +1,187 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package relabeltest runs unit tests of the relabel rules of a generated Prometheus config, in the style of
+// `promtool test rules`. Each test case feeds labels to the rules of one stage of a job or remote write and checks the
+// resulting labels, or that the target or series is dropped.
+package relabeltest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/relabeling"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/remotewrite"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/sharding"
+	"github.com/prometheus/prometheus/model/labels"
+	"gopkg.in/yaml.v3"
+)
+
+// Stage selects the relabel rules a test case runs.
+type Stage string
+
+const (
+	// StageRelabel runs the relabel_configs of a job over the labels of a discovered target.
+	StageRelabel Stage = "relabel"
+	// StageMetricRelabel runs the metric_relabel_configs of a job over the labels of a scraped series.
+	StageMetricRelabel Stage = "metric_relabel"
+	// StageWriteRelabel runs the write_relabel_configs of a remote write over the labels of a series.
+	StageWriteRelabel Stage = "write_relabel"
+)
+
+var ErrInvalidCase = errors.New("invalid test case")
+
+// Suite is the content of a test file.
+type Suite struct {
+	Tests []Case `yaml:"tests"`
+}
+
+// Case is a single test case.
+type Case struct {
+	Name string `yaml:"name"`
+	// Stage defaults to relabel.
+	Stage Stage `yaml:"stage"`
+	// Job is the name of the generated scrape job, required for the relabel and metric_relabel stages.
+	Job string `yaml:"job"`
+	// RemoteWrite is the name of the remote write for the write_relabel stage, defaults to the New Relic one.
+	RemoteWrite string `yaml:"remote_write"`
+	// InputLabels are the discovered labels of the target, or the labels of the series.
+	InputLabels map[string]string `yaml:"input_labels"`
+	// ExpectedLabels are the labels of the scraped series for the relabel stage, or the labels of the series once
+	// relabeled for the other stages.
+	ExpectedLabels map[string]string `yaml:"expected_labels"`
+	// ExpectDropped is set when the rules are expected to drop the target or series.
+	ExpectDropped bool `yaml:"expect_dropped"`
+}
+
+// Result is the outcome of a test case.
+type Result struct {
+	Case Case
+	// Err is set when the rules of the test case could not be run, like when the job does not exist.
+	Err error
+	// Dropped is set when the rules dropped the labels, DroppedBy being the 1-based position of the dropping rule.
+	Dropped   bool
+	DroppedBy int
+	// Labels holds the labels compared with the expected ones.
+	Labels labels.Labels
+}
+
+// Passed returns true if the outcome of the test case is the expected one.
+func (r Result) Passed() bool {
+	if r.Err != nil {
+		return false
+	}
+
+	if r.Case.ExpectDropped || r.Dropped {
+		return r.Case.ExpectDropped == r.Dropped
+	}
+
+	return labels.Equal(r.Labels, labels.FromMap(r.Case.ExpectedLabels))
+}
+
+// LoadSuite decodes a test file, failing on unknown fields and invalid test cases.
+func LoadSuite(data []byte) (*Suite, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	suite := &Suite{}
+	if err := decoder.Decode(suite); err != nil {
+		return nil, fmt.Errorf("parsing test file: %w", err)
+	}
+
+	for i := range suite.Tests {
+		c := &suite.Tests[i]
+		if err := c.validate(); err != nil {
+			return nil, fmt.Errorf("test %d %q: %w", i+1, c.Name, err)
+		}
+	}
+
+	return suite, nil
+}
+
+func (c *Case) validate() error {
+	if c.Stage == "" {
+		c.Stage = StageRelabel
+	}
+
+	switch c.Stage {
+	case StageRelabel, StageMetricRelabel:
+		if c.Job == "" {
+			return fmt.Errorf("%w: job is required for the %s stage", ErrInvalidCase, c.Stage)
+		}
+	case StageWriteRelabel:
+		if c.RemoteWrite == "" {
+			c.RemoteWrite = remotewrite.Name
+		}
+	default:
+		return fmt.Errorf("%w: unknown stage %q, expected relabel, metric_relabel or write_relabel", ErrInvalidCase, c.Stage)
+	}
+
+	if c.ExpectDropped == (c.ExpectedLabels != nil) {
+		return fmt.Errorf("%w: either expected_labels or expect_dropped must be set", ErrInvalidCase)
+	}
+
+	return nil
+}
+
+// Run runs the test cases over the relabel rules. The sharding config must be the one used to build the Prometheus
+// config: the sharding rules are not run so the results do not depend on the shard.
+func Run(config *relabeling.Config, shardingConfig sharding.Config, suite *Suite) []Result {
+	results := make([]Result, 0, len(suite.Tests))
+
+	for _, c := range suite.Tests {
+		result, err := run(config, shardingConfig, c)
+		result.Case = c
+		result.Err = err
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func run(config *relabeling.Config, shardingConfig sharding.Config, c Case) (Result, error) {
+	input := labels.FromMap(c.InputLabels)
+
+	switch c.Stage {
+	case StageMetricRelabel:
+		job, err := config.ScrapeConfig(c.Job)
+		if err != nil {
+			return Result{}, err //nolint: wrapcheck
+		}
+
+		return resultOf(relabeling.Process(job.MetricRelabelConfigs, input), false), nil
+	case StageWriteRelabel:
+		rw, err := config.RemoteWriteConfig(c.RemoteWrite)
+		if err != nil {
+			return Result{}, err //nolint: wrapcheck
+		}
+
+		return resultOf(relabeling.Process(rw.WriteRelabelConfigs, input), false), nil
+	default:
+		job, err := config.ScrapeConfig(c.Job)
+		if err != nil {
+			return Result{}, err //nolint: wrapcheck
+		}
+
+		rules, _, err := relabeling.SplitShardingRules(job.RelabelConfigs, shardingConfig)
+		if err != nil {
+			return Result{}, err //nolint: wrapcheck
+		}
+
+		return resultOf(relabeling.Process(rules, job.TargetLabels(input)), true), nil
+	}
+}
+
+func resultOf(processed relabeling.Result, target bool) Result {
+	if !processed.Kept {
+		return Result{Dropped: true, DroppedBy: processed.DroppedBy()}
+	}
+
+	if target {
+		return Result{Labels: relabeling.ScrapedLabels(processed.Labels)}
+	}
+
+	return Result{Labels: processed.Labels}
+}
@@ -0,0 +1,156 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package relabeltest_test
+
+import (
+	"testing"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/relabeling"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/relabeltest"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/sharding"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const promConfig = `
+scrape_configs:
+- job_name: pods
+  relabel_configs:
+  - source_labels: [__address__]
+    regex: \[([^\]]+)\](?::\d+)?|([^:]+)(?::\d+)?|(.+)
+    replacement: $1$2$3
+    target_label: __tmp_hash
+  - source_labels: [__tmp_hash]
+    modulus: 2
+    target_label: __tmp_hash
+    action: hashmod
+  - source_labels: [__tmp_hash]
+    regex: ^1$
+    action: keep
+  - source_labels: [__meta_kubernetes_pod_annotation_prometheus_io_scrape]
+    regex: "true"
+    action: keep
+  - source_labels: [__meta_kubernetes_namespace]
+    target_label: namespace
+  metric_relabel_configs:
+  - source_labels: [__name__]
+    regex: go_.*
+    action: drop
+remote_write:
+- name: newrelic_rw
+  url: https://metric-api.newrelic.com/prometheus/v1/write
+  write_relabel_configs:
+  - regex: pod_template_hash
+    action: labeldrop
+`
+
+const suite = `
+tests:
+- name: annotated pods are kept
+  job: pods
+  input_labels:
+    __address__: 10.0.0.1:8080
+    __meta_kubernetes_pod_annotation_prometheus_io_scrape: "true"
+    __meta_kubernetes_namespace: default
+  expected_labels:
+    instance: 10.0.0.1:8080
+    job: pods
+    namespace: default
+- name: pods without annotation are dropped
+  job: pods
+  input_labels:
+    __address__: 10.0.0.1:8080
+  expect_dropped: true
+- name: go metrics are dropped
+  stage: metric_relabel
+  job: pods
+  input_labels:
+    __name__: go_goroutines
+  expect_dropped: true
+- name: wrong expectation
+  stage: write_relabel
+  input_labels:
+    __name__: up
+    pod_template_hash: abc
+  expected_labels:
+    __name__: up
+    pod_template_hash: abc
+- name: missing job
+  job: nodes
+  input_labels: {}
+  expect_dropped: true
+`
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	config, err := relabeling.Load([]byte(promConfig))
+	require.NoError(t, err)
+
+	s, err := relabeltest.LoadSuite([]byte(suite))
+	require.NoError(t, err)
+
+	// The sharding rules are ignored, otherwise the address of the first case would be dropped.
+	results := relabeltest.Run(config, sharding.Config{TotalShardsCount: 2, ShardIndex: "1"}, s)
+	require.Len(t, results, 5)
+
+	assert.True(t, results[0].Passed())
+
+	assert.True(t, results[1].Passed())
+	assert.Equal(t, 1, results[1].DroppedBy)
+
+	assert.True(t, results[2].Passed())
+
+	assert.False(t, results[3].Passed())
+	assert.Equal(t, labels.FromStrings("__name__", "up"), results[3].Labels)
+
+	assert.False(t, results[4].Passed())
+	require.ErrorIs(t, results[4].Err, relabeling.ErrJobNotFound)
+}
+
+func TestLoadSuiteErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name  string
+		suite string
+		err   string
+	}{
+		{
+			name:  "UnknownField",
+			suite: "tests:\n- job: pods\n  expected_label: {}\n",
+			err:   "field expected_label not found",
+		},
+		{
+			name:  "UnknownStage",
+			suite: "tests:\n- job: pods\n  stage: scrape\n  expect_dropped: true\n",
+			err:   `unknown stage "scrape"`,
+		},
+		{
+			name:  "MissingJob",
+			suite: "tests:\n- expect_dropped: true\n",
+			err:   "job is required for the relabel stage",
+		},
+		{
+			name:  "MissingExpectation",
+			suite: "tests:\n- job: pods\n",
+			err:   "either expected_labels or expect_dropped must be set",
+		},
+		{
+			name:  "BothExpectations",
+			suite: "tests:\n- job: pods\n  expected_labels: {}\n  expect_dropped: true\n",
+			err:   "either expected_labels or expect_dropped must be set",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := relabeltest.LoadSuite([]byte(tc.suite))
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+)
+
+const (
+	// accountNameSeparator joins Name and the account name in the remote write name of the accounts.
+	accountNameSeparator = "_"
+	namespaceLabel       = "namespace"
+	jobLabel             = "job"
+	selectorSeparator    = ";"
+)
+
+var ErrAccount = errors.New("Account Error")
+
+// accountNameRegex matches the valid account names, which are part of the remote write names.
+var accountNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`) //nolint: gochecknoglobals
+
+// Account is an additional New Relic account receiving the metrics matching its selector. The rest of the remote
+// write settings, like the proxy or the queue config, are the ones of the default account.
+type Account struct {
+	// Name identifies the account, its remote write is named `newrelic_rw_<name>`.
+	Name string `yaml:"name"`
+	// LicenseKey holds the New Relic ingest license key of the account.
+	LicenseKey string `yaml:"license_key" secret:"true"`
+	// LicenseKeyFile is the path of a file holding the license key. It cannot be set along with LicenseKey.
+	LicenseKeyFile string `yaml:"license_key_file"`
+	// DataSourceName overrides the data source name of the default account.
+	DataSourceName string `yaml:"data_source_name"`
+	// Region overrides the region of the default account, like `eu`. The region of the default account is not inherited
+	// when the license key of the account holds one.
+	Region string `yaml:"region"`
+	// Endpoint overrides the endpoint of the default account.
+	Endpoint string `yaml:"endpoint"`
+	// Selector selects the metrics sent to the account.
+	Selector Selector `yaml:"selector"`
+}
+
+// Selector matches the metrics having all the criteria set: a namespace in Namespaces, a job in Jobs and every label in
+// Labels matching its regex.
+type Selector struct {
+	Namespaces []string          `yaml:"namespaces"`
+	Jobs       []string          `yaml:"jobs"`
+	Labels     map[string]string `yaml:"labels"`
+}
+
+// RemoteWriteName returns the name of the remote write of the account.
+func (a Account) RemoteWriteName() string {
+	return Name + accountNameSeparator + a.Name
+}
+
+// CatchAllEnabled returns true when the default account is added to the remote writes, receiving the metrics not
+// matching the selector of any account.
+func (c Config) CatchAllEnabled() bool {
+	return len(c.Accounts) == 0 || c.CatchAll == nil || *c.CatchAll
+}
+
+// BuildAll creates the Prometheus remote_write entries of the default account, if enabled, followed by the ones of
+// the accounts. Each account keeps only the metrics matching its selector and the default account drops all of them.
+func (c Config) BuildAll() ([]promcfg.RemoteWrite, error) {
+	if err := c.validateAccounts(); err != nil {
+		return nil, err
+	}
+
+	remoteWrites := make([]promcfg.RemoteWrite, 0, len(c.Accounts)+1)
+
+	if c.CatchAllEnabled() {
+		rw, err := c.Build()
+		if err != nil {
+			return nil, err
+		}
+
+		routing := make([]promcfg.RelabelConfig, 0, len(c.Accounts))
+		for _, account := range c.Accounts {
+			routing = append(routing, account.Selector.relabelConfig("drop"))
+		}
+
+		rw.WriteRelabelConfigs = append(routing, rw.WriteRelabelConfigs...)
+		remoteWrites = append(remoteWrites, rw)
+	}
+
+	for _, account := range c.Accounts {
+		rw, err := c.accountConfig(account).Build()
+		if err != nil {
+			return nil, fmt.Errorf("account %q: %w", account.Name, err)
+		}
+
+		rw.Name = account.RemoteWriteName()
+		rw.WriteRelabelConfigs = append([]promcfg.RelabelConfig{account.Selector.relabelConfig("keep")}, rw.WriteRelabelConfigs...)
+		remoteWrites = append(remoteWrites, rw)
+	}
+
+	return remoteWrites, nil
+}
+
+// accountConfig returns the config of the default account with the settings of the account.
+func (c Config) accountConfig(account Account) Config {
+	accountConfig := c
+	accountConfig.Accounts = nil
+	accountConfig.LicenseKey = account.LicenseKey
+	accountConfig.LicenseKeyFile = ""
+
+	if account.DataSourceName != "" {
+		accountConfig.DataSourceName = account.DataSourceName
+	}
+
+	switch {
+	case account.Region != "":
+		accountConfig.Region = account.Region
+	case licenseGetRegion(account.LicenseKey) != "":
+		// The account may belong to a region other than the one of the default account.
+		accountConfig.Region = ""
+	}
+
+	if account.Endpoint != "" {
+		accountConfig.Endpoint = account.Endpoint
+	}
+
+	if c.CredentialsFile != "" {
+		accountConfig.CredentialsFile = AccountCredentialsFile(c.CredentialsFile, account.Name)
+	}
+
+	return accountConfig
+}
+
+// AccountCredentialsFile returns the path of the credentials file of the account, next to the one of the default
+// account.
+func AccountCredentialsFile(credentialsFile string, accountName string) string {
+	return credentialsFile + "-" + accountName
+}
+
+func (c Config) validateAccounts() error {
+	names := map[string]bool{}
+
+	for _, account := range c.Accounts {
+		if !accountNameRegex.MatchString(account.Name) {
+			return fmt.Errorf("%w: The account name %q must only hold letters, digits, `_` and `-`", ErrAccount, account.Name)
+		}
+
+		if names[account.Name] {
+			return fmt.Errorf("%w: The account name %q is repeated", ErrAccount, account.Name)
+		}
+
+		names[account.Name] = true
+
+		if account.LicenseKey == "" {
+			return fmt.Errorf("%w: The account %q has no license key", ErrAccount, account.Name)
+		}
+
+		if err := account.Selector.validate(); err != nil {
+			return fmt.Errorf("%w: The selector of the account %q %w", ErrAccount, account.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s Selector) validate() error {
+	if len(s.Namespaces) == 0 && len(s.Jobs) == 0 && len(s.Labels) == 0 {
+		return errors.New("is empty, it would match every metric")
+	}
+
+	for name, regex := range s.Labels {
+		if _, err := regexp.Compile(regex); err != nil {
+			return fmt.Errorf("has an invalid regex for the label %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// relabelConfig returns a rule applying the action to the metrics matching the selector. The labels of every criteria
+// are joined, so a single rule requires all of them to match.
+func (s Selector) relabelConfig(action string) promcfg.RelabelConfig {
+	var sourceLabels, regexes []string
+
+	if len(s.Namespaces) > 0 {
+		sourceLabels = append(sourceLabels, namespaceLabel)
+		regexes = append(regexes, anyOf(s.Namespaces))
+	}
+
+	if len(s.Jobs) > 0 {
+		sourceLabels = append(sourceLabels, jobLabel)
+		regexes = append(regexes, anyOf(s.Jobs))
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(s.Labels)) {
+		sourceLabels = append(sourceLabels, name)
+		regexes = append(regexes, "(?:"+s.Labels[name]+")")
+	}
+
+	return promcfg.RelabelConfig{
+		SourceLabels: sourceLabels,
+		Separator:    selectorSeparator,
+		Regex:        strings.Join(regexes, selectorSeparator),
+		Action:       action,
+	}
+}
+
+// anyOf returns a regex matching any of the values literally.
+func anyOf(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, v := range values {
+		quoted = append(quoted, regexp.QuoteMeta(v))
+	}
+
+	return "(?:" + strings.Join(quoted, "|") + ")"
+}
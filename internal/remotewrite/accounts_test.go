@@ -0,0 +1,198 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package remotewrite_test
+
+import (
+	"testing"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/remotewrite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func accountsConfig() remotewrite.Config {
+	return remotewrite.Config{
+		LicenseKey:     "default-key",
+		DataSourceName: "cluster",
+		ExtraWriteRelabelConfigs: []promcfg.RelabelConfig{
+			{SourceLabels: []string{"__name__"}, Regex: "go_.*", Action: "drop"},
+		},
+		Accounts: []remotewrite.Account{
+			{
+				Name:       "team-a",
+				LicenseKey: "eu01xxteam-a-key",
+				Selector:   remotewrite.Selector{Namespaces: []string{"team-a", "team.a-staging"}},
+			},
+			{
+				Name:           "payments",
+				LicenseKey:     "payments-key",
+				DataSourceName: "payments-cluster",
+				Selector: remotewrite.Selector{
+					Jobs:   []string{"kubernetes-pods"},
+					Labels: map[string]string{"team": "payments|billing", "app": ".+"},
+				},
+			},
+		},
+	}
+}
+
+func TestBuildAll(t *testing.T) {
+	t.Parallel()
+
+	remoteWrites, err := accountsConfig().BuildAll()
+	require.NoError(t, err)
+	require.Len(t, remoteWrites, 3)
+
+	userRule := promcfg.RelabelConfig{SourceLabels: []string{"__name__"}, Regex: "go_.*", Action: "drop"}
+	teamARule := promcfg.RelabelConfig{
+		SourceLabels: []string{"namespace"},
+		Separator:    ";",
+		Regex:        `(?:team-a|team\.a-staging)`,
+	}
+	paymentsRule := promcfg.RelabelConfig{
+		SourceLabels: []string{"job", "app", "team"},
+		Separator:    ";",
+		Regex:        `(?:kubernetes-pods);(?:.+);(?:payments|billing)`,
+	}
+
+	defaultRW := remoteWrites[0]
+	assert.Equal(t, remotewrite.Name, defaultRW.Name)
+	assert.Equal(t, "default-key", defaultRW.Authorization.Credentials)
+	assert.Equal(t, []promcfg.RelabelConfig{withAction(teamARule, "drop"), withAction(paymentsRule, "drop"), userRule}, defaultRW.WriteRelabelConfigs)
+
+	teamA := remoteWrites[1]
+	assert.Equal(t, "newrelic_rw_team-a", teamA.Name)
+	assert.Equal(t, "eu01xxteam-a-key", teamA.Authorization.Credentials)
+	assert.Equal(t, "https://metric-api.eu.newrelic.com/prometheus/v1/write?collector_name=prometheus-agent&prometheus_server=cluster", teamA.URL)
+	assert.Equal(t, []promcfg.RelabelConfig{withAction(teamARule, "keep"), userRule}, teamA.WriteRelabelConfigs)
+
+	payments := remoteWrites[2]
+	assert.Equal(t, "newrelic_rw_payments", payments.Name)
+	assert.Contains(t, payments.URL, "prometheus_server=payments-cluster")
+	assert.Equal(t, []promcfg.RelabelConfig{withAction(paymentsRule, "keep"), userRule}, payments.WriteRelabelConfigs)
+}
+
+func TestBuildAllWithoutCatchAll(t *testing.T) {
+	t.Parallel()
+
+	disabled := false
+	config := accountsConfig()
+	config.CatchAll = &disabled
+	config.LicenseKey = ""
+	config.CredentialsFile = "/etc/prometheus/license-key"
+
+	remoteWrites, err := config.BuildAll()
+	require.NoError(t, err)
+	require.Len(t, remoteWrites, 2)
+	assert.Equal(t, "newrelic_rw_team-a", remoteWrites[0].Name)
+	assert.Equal(t, promcfg.Authorization{CredentialsFile: "/etc/prometheus/license-key-team-a"}, remoteWrites[0].Authorization)
+}
+
+func TestBuildAllAccountRegions(t *testing.T) {
+	t.Parallel()
+
+	config := remotewrite.Config{
+		LicenseKey: "eu01xxdefault-key",
+		Region:     "eu",
+		Accounts: []remotewrite.Account{
+			{
+				Name:       "japan",
+				LicenseKey: "jp01xxjapan-key",
+				Selector:   remotewrite.Selector{Namespaces: []string{"japan"}},
+			},
+			{
+				Name:       "us",
+				LicenseKey: "us-key",
+				Region:     "us",
+				Endpoint:   "https://proxy.local/write",
+				Selector:   remotewrite.Selector{Namespaces: []string{"us"}},
+			},
+		},
+	}
+
+	remoteWrites, err := config.BuildAll()
+	require.NoError(t, err)
+	require.Len(t, remoteWrites, 3)
+	assert.Equal(t, "https://metric-api.eu.newrelic.com/prometheus/v1/write?collector_name=prometheus-agent", remoteWrites[0].URL)
+	assert.Equal(t, "https://metric-api.jp.nr-data.net/prometheus/v1/write?collector_name=prometheus-agent", remoteWrites[1].URL)
+	assert.Equal(t, "https://proxy.local/write?collector_name=prometheus-agent", remoteWrites[2].URL)
+}
+
+func TestBuildAllErrors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name            string
+		Modify          func(config *remotewrite.Config)
+		ExpectedError   error
+		ExpectedMessage string
+	}{
+		{
+			Name:            "invalid name",
+			Modify:          func(config *remotewrite.Config) { config.Accounts[0].Name = "team a" },
+			ExpectedError:   remotewrite.ErrAccount,
+			ExpectedMessage: "Account Error: The account name \"team a\" must only hold letters, digits, `_` and `-`",
+		},
+		{
+			Name:            "repeated name",
+			Modify:          func(config *remotewrite.Config) { config.Accounts[1].Name = "team-a" },
+			ExpectedError:   remotewrite.ErrAccount,
+			ExpectedMessage: `Account Error: The account name "team-a" is repeated`,
+		},
+		{
+			Name:            "missing license key",
+			Modify:          func(config *remotewrite.Config) { config.Accounts[1].LicenseKey = "" },
+			ExpectedError:   remotewrite.ErrAccount,
+			ExpectedMessage: `Account Error: The account "payments" has no license key`,
+		},
+		{
+			Name:            "empty selector",
+			Modify:          func(config *remotewrite.Config) { config.Accounts[0].Selector = remotewrite.Selector{} },
+			ExpectedError:   remotewrite.ErrAccount,
+			ExpectedMessage: `Account Error: The selector of the account "team-a" is empty, it would match every metric`,
+		},
+		{
+			Name:          "invalid label regex",
+			Modify:        func(config *remotewrite.Config) { config.Accounts[1].Selector.Labels["team"] = "(payments" },
+			ExpectedError: remotewrite.ErrAccount,
+			ExpectedMessage: `Account Error: The selector of the account "payments" has an invalid regex for the label team: ` +
+				"error parsing regexp: missing closing ): `(payments`",
+		},
+		{
+			Name: "account inheriting a region not matching its license key",
+			Modify: func(config *remotewrite.Config) {
+				config.LicenseKey = "eu01xxdefault-key"
+				config.Region = "eu"
+			},
+			ExpectedError:   remotewrite.ErrRegion,
+			ExpectedMessage: `account "payments": Region Error: The region eu does not match the region us of the license key`,
+		},
+		{
+			Name:            "account region and FedRAMP",
+			Modify:          func(config *remotewrite.Config) { config.FedRAMP.Enabled = true },
+			ExpectedError:   remotewrite.ErrFedRAMPRegions,
+			ExpectedMessage: `account "team-a": FedRAMP Region Error: There is no FedRamp compatible endpoints for the region eu`,
+		},
+	}
+
+	for _, testCase := range cases {
+		c := testCase
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+
+			config := accountsConfig()
+			c.Modify(&config)
+
+			_, err := config.BuildAll()
+			assert.ErrorIs(t, err, c.ExpectedError)
+			assert.EqualError(t, err, c.ExpectedMessage)
+		})
+	}
+}
+
+func withAction(rule promcfg.RelabelConfig, action string) promcfg.RelabelConfig {
+	rule.Action = action
+	return rule
+}
@@ -15,24 +15,58 @@ const Name = "newrelic_rw"
 // Config defines all the NewRelic's remote write endpoint fields.
 type Config struct {
 	// LicenseKey holds the New Relic ingest license key of the account where metrics will be sent.
-	LicenseKey string `yaml:"license_key"`
+	LicenseKey string `yaml:"license_key" secret:"true"`
+	// LicenseKeyFile is the path of a file holding the license key, like a mounted Secret. It cannot be set along with
+	// LicenseKey.
+	LicenseKeyFile string `yaml:"license_key_file"`
 	// Staging configures the remote write url to point to the New Relic staging endpoint.
 	Staging bool `yaml:"staging"`
 	// ChartVersion holds the Chart version of the prometheus-configurator.
 	ChartVersion string `yaml:"-"`
+	// CredentialsFile is the path where the license key is written for Prometheus to read it. When set the license key
+	// is not inlined in the remote write entry.
+	CredentialsFile string `yaml:"-"`
 	// DataSourceName holds the source name which will be used as `prometheus_server` parameter in New Relic remote
 	// write endpoint. See:
 	// <https://docs.newrelic.com/docs/infrastructure/prometheus-integrations/install-configure-remote-write/set-your-prometheus-remote-write-integration/>
 	// for details.
 	DataSourceName string `yaml:"data_source_name"`
 	// FedRAMP configures the remote write url to point to the New Relic FedRAMP endpoint.
-	FedRAMP                  FedRAMP                 `yaml:"fedramp"`
-	ProxyURL                 string                  `yaml:"proxy_url"`
+	FedRAMP FedRAMP `yaml:"fedramp"`
+	// Region sets the region of the New Relic endpoint, like `eu`. It must match the region of the license key, `us`
+	// for keys without a region prefix.
+	Region string `yaml:"region"`
+	// Endpoint replaces the New Relic remote write URL, like a private link, a regional proxy or a test receiver.
+	Endpoint                 string                  `yaml:"endpoint" secret:"url"`
+	ProxyURL                 string                  `yaml:"proxy_url" secret:"url"`
 	TLSConfig                *promcfg.TLSConfig      `yaml:"tls_config"`
 	QueueConfig              *promcfg.QueueConfig    `yaml:"queue_config"`
 	RemoteTimeout            time.Duration           `yaml:"remote_timeout"`
 	ExtraWriteRelabelConfigs []promcfg.RelabelConfig `yaml:"extra_write_relabel_configs"`
 	ProxyFromEnvironment     bool                    `yaml:"proxy_from_environment,omitempty"`
+	MetadataConfig           *promcfg.MetadataConfig `yaml:"metadata_config"`
+	// SendExemplars and SendNativeHistograms are not ingested by the New Relic endpoint, see UnsupportedSettings.
+	SendExemplars        bool `yaml:"send_exemplars"`
+	SendNativeHistograms bool `yaml:"send_native_histograms"`
+	// Headers are sent along with every request, like the ones required by a proxy set as Endpoint.
+	Headers map[string]string `yaml:"headers" secret:"values"`
+	// QueueProfile selects a preset of the queue config, by default the one sized for EstimatedSamplesPerSecond if it
+	// is set, otherwise QueueConfig is used as it is. The fields set in QueueConfig override the ones of the preset.
+	QueueProfile              QueueProfile `yaml:"queue_profile"`
+	EstimatedSamplesPerSecond int          `yaml:"estimated_samples_per_second"`
+	// Metrics filters the metrics sent to New Relic before any other write relabel rule is applied. Other remote write
+	// destinations are not filtered.
+	Metrics MetricsFilter `yaml:"metrics"`
+	// LowDataMode drops the metrics of its tier before the ExtraWriteRelabelConfigs are applied.
+	LowDataMode LowDataMode `yaml:"low_data_mode"`
+	// MetricTypeOverrides sets the New Relic type of metrics, after the low data mode and before the
+	// ExtraWriteRelabelConfigs are applied.
+	MetricTypeOverrides MetricTypeOverrides `yaml:"metric_type_overrides"`
+	// Accounts are additional New Relic accounts receiving the metrics matching their selector.
+	Accounts []Account `yaml:"accounts"`
+	// CatchAll sets whether this account receives the metrics not matching the selector of any of the Accounts,
+	// defaults to true.
+	CatchAll *bool `yaml:"catch_all"`
 }
 
 // FedRAMP in charts are configured like `.fedramp.enabled: true` just in case we have to
@@ -46,6 +80,8 @@ func (c Config) Build() (promcfg.RemoteWrite, error) {
 	rwu := NewURL(
 		WithFedRAMP(c.FedRAMP.Enabled),
 		WithLicense(c.LicenseKey),
+		WithRegion(c.Region),
+		WithEndpoint(c.Endpoint),
 		WithStaging(c.Staging),
 		WithDataSourceName(c.DataSourceName),
 		WithCollectorName(collectorName),
@@ -57,17 +93,81 @@ func (c Config) Build() (promcfg.RemoteWrite, error) {
 		return promcfg.RemoteWrite{}, err
 	}
 
+	queueConfig, err := c.queueConfig()
+	if err != nil {
+		return promcfg.RemoteWrite{}, err
+	}
+
+	metricsRules, err := c.Metrics.RelabelConfigs()
+	if err != nil {
+		return promcfg.RemoteWrite{}, err
+	}
+
+	lowDataModeRules, err := c.LowDataMode.RelabelConfigs()
+	if err != nil {
+		return promcfg.RemoteWrite{}, err
+	}
+
+	metricTypeRules, err := c.MetricTypeOverrides.RelabelConfigs()
+	if err != nil {
+		return promcfg.RemoteWrite{}, err
+	}
+
+	writeRelabelConfigs := append(metricsRules, lowDataModeRules...)
+	writeRelabelConfigs = append(writeRelabelConfigs, metricTypeRules...)
+
 	rw := promcfg.RemoteWrite{
 		Name:                 Name,
 		URL:                  url,
 		RemoteTimeout:        c.RemoteTimeout,
 		ProxyFromEnvironment: c.ProxyFromEnvironment,
-		Authorization:        promcfg.Authorization{Credentials: c.LicenseKey},
+		Authorization:        c.authorization(),
 		TLSConfig:            c.TLSConfig,
 		ProxyURL:             c.ProxyURL,
-		QueueConfig:          c.QueueConfig,
-		WriteRelabelConfigs:  c.ExtraWriteRelabelConfigs,
+		QueueConfig:          queueConfig,
+		MetadataConfig:       c.MetadataConfig,
+		WriteRelabelConfigs:  append(writeRelabelConfigs, c.ExtraWriteRelabelConfigs...),
+		SendExemplars:        c.SendExemplars,
+		SendNativeHistograms: c.SendNativeHistograms,
+		Headers:              c.Headers,
 	}
 
 	return rw, nil
 }
+
+// UnsupportedSetting is a setting accepted by Prometheus which has no effect on the New Relic endpoint.
+type UnsupportedSetting struct {
+	// Field is the yaml path of the setting relative to the remote write config, like `send_exemplars`.
+	Field  string
+	Reason string
+}
+
+// UnsupportedSettings returns the settings enabled which the New Relic endpoint does not support. They are still
+// passed to Prometheus, since they do not prevent the rest of the data from being ingested.
+func (c Config) UnsupportedSettings() []UnsupportedSetting {
+	var unsupported []UnsupportedSetting
+
+	if c.SendExemplars {
+		unsupported = append(unsupported, UnsupportedSetting{
+			Field:  "send_exemplars",
+			Reason: "the New Relic Prometheus endpoint does not ingest exemplars, they are discarded",
+		})
+	}
+
+	if c.SendNativeHistograms {
+		unsupported = append(unsupported, UnsupportedSetting{
+			Field:  "send_native_histograms",
+			Reason: "the New Relic Prometheus endpoint does not ingest native histograms, use classic histograms instead",
+		})
+	}
+
+	return unsupported
+}
+
+func (c Config) authorization() promcfg.Authorization {
+	if c.CredentialsFile != "" {
+		return promcfg.Authorization{CredentialsFile: c.CredentialsFile}
+	}
+
+	return promcfg.Authorization{Credentials: c.LicenseKey}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/newrelic/newrelic-prometheus-configurator/internal/remotewrite"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 //nolint:funlen
@@ -43,6 +44,38 @@ func TestBuildRemoteWritePromConfig(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name: "Endpoint override",
+			NrConfig: args{
+				remoteConfig: remotewrite.Config{
+					LicenseKey: "fake-prod",
+					Endpoint:   "https://private-link.example.com/prometheus/v1/write",
+				},
+			},
+			Expected: promcfg.RemoteWrite{
+				Name: remotewrite.Name,
+				URL:  "https://private-link.example.com/prometheus/v1/write?collector_name=prometheus-agent",
+				Authorization: promcfg.Authorization{
+					Credentials: "fake-prod",
+				},
+			},
+		},
+		{
+			Name: "License key read from the credentials file",
+			NrConfig: args{
+				remoteConfig: remotewrite.Config{
+					LicenseKey:      "fake-prod",
+					CredentialsFile: "/etc/prometheus/license-key",
+				},
+			},
+			Expected: promcfg.RemoteWrite{
+				Name: remotewrite.Name,
+				URL:  "https://metric-api.newrelic.com/prometheus/v1/write?collector_name=prometheus-agent",
+				Authorization: promcfg.Authorization{
+					CredentialsFile: "/etc/prometheus/license-key",
+				},
+			},
+		},
 		{
 			Name: "Staging, eu and all fields set with ProxyURL",
 			NrConfig: args{
@@ -200,6 +233,38 @@ func TestBuildRemoteWritePromConfig(t *testing.T) {
 	}
 }
 
+func TestBuildRemoteWriteOptions(t *testing.T) {
+	t.Parallel()
+
+	send := false
+	config := remotewrite.Config{
+		LicenseKey:           "fake",
+		MetadataConfig:       &promcfg.MetadataConfig{Send: &send},
+		SendExemplars:        true,
+		SendNativeHistograms: true,
+		Headers:              map[string]string{"X-Proxy-Token": "token"},
+	}
+
+	rw, err := config.Build()
+	require.NoError(t, err)
+	assert.Equal(t, &promcfg.MetadataConfig{Send: &send}, rw.MetadataConfig)
+	assert.True(t, rw.SendExemplars)
+	assert.True(t, rw.SendNativeHistograms)
+	assert.Equal(t, map[string]string{"X-Proxy-Token": "token"}, rw.Headers)
+
+	assert.Equal(t, []string{"send_exemplars", "send_native_histograms"}, unsupportedFields(config.UnsupportedSettings()))
+	assert.Empty(t, remotewrite.Config{LicenseKey: "fake"}.UnsupportedSettings())
+}
+
+func unsupportedFields(settings []remotewrite.UnsupportedSetting) []string {
+	fields := make([]string, 0, len(settings))
+	for _, setting := range settings {
+		fields = append(fields, setting.Field)
+	}
+
+	return fields
+}
+
 func TestBuildRemoteWritePromConfig_Errors(t *testing.T) {
 	t.Parallel()
 
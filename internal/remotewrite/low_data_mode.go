@@ -0,0 +1,151 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+)
+
+// Tier selects the rules of the low data mode.
+type Tier string
+
+const (
+	// TierOff sends every metric, it is the default.
+	TierOff Tier = "off"
+	// TierStandard drops the metrics already collected by the New Relic Kubernetes integration.
+	TierStandard Tier = "standard"
+	// TierAggressive drops as well the Kubernetes components metrics and the histogram buckets.
+	TierAggressive Tier = "aggressive"
+
+	// lowDataModeKeepLabel flags the metrics excepted from a low data mode rule while it is applied.
+	lowDataModeKeepLabel = "__tmp_low_data_mode_keep"
+)
+
+var ErrLowDataMode = errors.New("Low Data Mode Error")
+
+// LowDataMode reduces the metrics sent to New Relic dropping the ones matching the rules of the tier.
+type LowDataMode struct {
+	Tier Tier `yaml:"tier"`
+	// Exceptions holds, by rule name, regexes of the metric names which are kept even if the rule matches them.
+	Exceptions map[string][]string `yaml:"exceptions"`
+}
+
+// lowDataModeRule drops the metrics whose name matches the regex from the tier on.
+type lowDataModeRule struct {
+	name  string
+	regex string
+	tiers []Tier
+}
+
+// lowDataModeRules are applied in order.
+//
+//nolint:gochecknoglobals
+var lowDataModeRules = []lowDataModeRule{
+	{
+		// Collected by the New Relic Kubernetes integration.
+		name:  "kubernetes",
+		regex: "kube_.+|container_.+|machine_.+|cadvisor_.+",
+		tiers: []Tier{TierStandard, TierAggressive},
+	},
+	{
+		// Collected by the control plane and kubelet components of the New Relic Kubernetes integration.
+		name:  "kubernetes_components",
+		regex: "apiserver_.+|etcd_.+|scheduler_.+|kubelet_.+|kubeproxy_.+|workqueue_.+|rest_client_.+",
+		tiers: []Tier{TierAggressive},
+	},
+	{
+		name:  "histogram_buckets",
+		regex: ".+_bucket",
+		tiers: []Tier{TierAggressive},
+	},
+}
+
+// RelabelConfigs returns the write relabel rules dropping the metrics of the tier, none if it is off.
+func (l LowDataMode) RelabelConfigs() ([]promcfg.RelabelConfig, error) {
+	rules, err := l.rules()
+	if err != nil {
+		return nil, err
+	}
+
+	var relabelConfigs []promcfg.RelabelConfig
+
+	for _, rule := range rules {
+		relabelConfigs = append(relabelConfigs, rule.relabelConfigs(l.Exceptions[rule.name])...)
+	}
+
+	return relabelConfigs, nil
+}
+
+// rules returns the rules of the tier, checking the exceptions refer to them.
+func (l LowDataMode) rules() ([]lowDataModeRule, error) {
+	tier := l.Tier
+	if tier == "" {
+		tier = TierOff
+	}
+
+	if !slices.Contains([]Tier{TierOff, TierStandard, TierAggressive}, tier) {
+		return nil, fmt.Errorf("%w: The tier %q is not one of off, standard or aggressive", ErrLowDataMode, tier)
+	}
+
+	var rules []lowDataModeRule
+
+	for _, rule := range lowDataModeRules {
+		if slices.Contains(rule.tiers, tier) {
+			rules = append(rules, rule)
+		}
+	}
+
+	for name, exceptions := range l.Exceptions {
+		if !slices.ContainsFunc(rules, func(rule lowDataModeRule) bool { return rule.name == name }) {
+			return nil, fmt.Errorf("%w: The exceptions refer to %q, which is not a rule of the %s tier", ErrLowDataMode, name, tier)
+		}
+
+		for _, exception := range exceptions {
+			if _, err := regexp.Compile(exception); err != nil {
+				return nil, fmt.Errorf("%w: The exception %q of the rule %s is not a valid regex: %w", ErrLowDataMode, exception, name, err)
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+// relabelConfigs returns a single drop rule, or when there are exceptions, a rule flagging the metrics excepted, the
+// drop rule skipping them and a rule removing the flag.
+func (r lowDataModeRule) relabelConfigs(exceptions []string) []promcfg.RelabelConfig {
+	if len(exceptions) == 0 {
+		return []promcfg.RelabelConfig{{SourceLabels: []string{"__name__"}, Regex: r.regex, Action: "drop"}}
+	}
+
+	anyException := make([]string, 0, len(exceptions))
+	for _, exception := range exceptions {
+		anyException = append(anyException, "(?:"+exception+")")
+	}
+
+	return []promcfg.RelabelConfig{
+		{
+			SourceLabels: []string{"__name__"},
+			Regex:        strings.Join(anyException, "|"),
+			TargetLabel:  lowDataModeKeepLabel,
+			Replacement:  "true",
+			Action:       "replace",
+		},
+		{
+			SourceLabels: []string{lowDataModeKeepLabel, "__name__"},
+			Separator:    ";",
+			Regex:        ";(?:" + r.regex + ")",
+			Action:       "drop",
+		},
+		{
+			Regex:  lowDataModeKeepLabel,
+			Action: "labeldrop",
+		},
+	}
+}
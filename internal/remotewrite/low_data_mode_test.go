@@ -0,0 +1,187 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package remotewrite_test
+
+import (
+	"testing"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/relabeling"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/relabeltest"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/remotewrite"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/sharding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestLowDataModeRelabelConfigs(t *testing.T) {
+	t.Parallel()
+
+	kubernetesRule := promcfg.RelabelConfig{
+		SourceLabels: []string{"__name__"},
+		Regex:        "kube_.+|container_.+|machine_.+|cadvisor_.+",
+		Action:       "drop",
+	}
+
+	cases := []struct {
+		Name     string
+		Config   remotewrite.LowDataMode
+		Expected []promcfg.RelabelConfig
+	}{
+		{
+			Name:     "off by default",
+			Config:   remotewrite.LowDataMode{},
+			Expected: nil,
+		},
+		{
+			Name:     "off",
+			Config:   remotewrite.LowDataMode{Tier: remotewrite.TierOff},
+			Expected: nil,
+		},
+		{
+			Name:     "standard",
+			Config:   remotewrite.LowDataMode{Tier: remotewrite.TierStandard},
+			Expected: []promcfg.RelabelConfig{kubernetesRule},
+		},
+		{
+			Name: "standard with exceptions",
+			Config: remotewrite.LowDataMode{
+				Tier:       remotewrite.TierStandard,
+				Exceptions: map[string][]string{"kubernetes": {"kube_pod_status_phase", "container_cpu_.+"}},
+			},
+			Expected: []promcfg.RelabelConfig{
+				{
+					SourceLabels: []string{"__name__"},
+					Regex:        "(?:kube_pod_status_phase)|(?:container_cpu_.+)",
+					TargetLabel:  "__tmp_low_data_mode_keep",
+					Replacement:  "true",
+					Action:       "replace",
+				},
+				{
+					SourceLabels: []string{"__tmp_low_data_mode_keep", "__name__"},
+					Separator:    ";",
+					Regex:        ";(?:kube_.+|container_.+|machine_.+|cadvisor_.+)",
+					Action:       "drop",
+				},
+				{Regex: "__tmp_low_data_mode_keep", Action: "labeldrop"},
+			},
+		},
+		{
+			Name:   "aggressive",
+			Config: remotewrite.LowDataMode{Tier: remotewrite.TierAggressive},
+			Expected: []promcfg.RelabelConfig{
+				kubernetesRule,
+				{
+					SourceLabels: []string{"__name__"},
+					Regex:        "apiserver_.+|etcd_.+|scheduler_.+|kubelet_.+|kubeproxy_.+|workqueue_.+|rest_client_.+",
+					Action:       "drop",
+				},
+				{SourceLabels: []string{"__name__"}, Regex: ".+_bucket", Action: "drop"},
+			},
+		},
+	}
+
+	for _, testCase := range cases {
+		c := testCase
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+
+			rules, err := c.Config.RelabelConfigs()
+			require.NoError(t, err)
+			assert.Equal(t, c.Expected, rules)
+		})
+	}
+}
+
+func TestLowDataModeErrors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name            string
+		Config          remotewrite.LowDataMode
+		ExpectedMessage string
+	}{
+		{
+			Name:            "unknown tier",
+			Config:          remotewrite.LowDataMode{Tier: "minimal"},
+			ExpectedMessage: `Low Data Mode Error: The tier "minimal" is not one of off, standard or aggressive`,
+		},
+		{
+			Name: "exception of a rule not in the tier",
+			Config: remotewrite.LowDataMode{
+				Tier:       remotewrite.TierStandard,
+				Exceptions: map[string][]string{"histogram_buckets": {"http_.+"}},
+			},
+			ExpectedMessage: `Low Data Mode Error: The exceptions refer to "histogram_buckets", which is not a rule of the standard tier`,
+		},
+		{
+			Name: "invalid exception",
+			Config: remotewrite.LowDataMode{
+				Tier:       remotewrite.TierStandard,
+				Exceptions: map[string][]string{"kubernetes": {"kube_(.+"}},
+			},
+			ExpectedMessage: "Low Data Mode Error: The exception \"kube_(.+\" of the rule kubernetes is not a valid regex: " +
+				"error parsing regexp: missing closing ): `kube_(.+`",
+		},
+	}
+
+	for _, testCase := range cases {
+		c := testCase
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := c.Config.RelabelConfigs()
+			assert.ErrorIs(t, err, remotewrite.ErrLowDataMode)
+			assert.EqualError(t, err, c.ExpectedMessage)
+		})
+	}
+}
+
+func TestLowDataModeDropsMetrics(t *testing.T) {
+	t.Parallel()
+
+	config := remotewrite.Config{
+		LicenseKey: "fake",
+		LowDataMode: remotewrite.LowDataMode{
+			Tier:       remotewrite.TierAggressive,
+			Exceptions: map[string][]string{"kubernetes": {"kube_pod_status_phase"}},
+		},
+		ExtraWriteRelabelConfigs: []promcfg.RelabelConfig{{Regex: "pod_template_hash", Action: "labeldrop"}},
+	}
+
+	rw, err := config.Build()
+	require.NoError(t, err)
+
+	data, err := yaml.Marshal(map[string]any{"remote_write": []promcfg.RemoteWrite{rw}})
+	require.NoError(t, err)
+
+	relabelConfig, err := relabeling.Load(data)
+	require.NoError(t, err)
+
+	suite, err := relabeltest.LoadSuite([]byte(`
+tests:
+- name: kubernetes metrics are dropped
+  stage: write_relabel
+  input_labels: {__name__: kube_pod_info}
+  expect_dropped: true
+- name: excepted metrics are kept without the flag
+  stage: write_relabel
+  input_labels: {__name__: kube_pod_status_phase, pod_template_hash: abc}
+  expected_labels: {__name__: kube_pod_status_phase}
+- name: histogram buckets are dropped
+  stage: write_relabel
+  input_labels: {__name__: http_request_duration_seconds_bucket, le: "0.5"}
+  expect_dropped: true
+- name: other metrics are kept
+  stage: write_relabel
+  input_labels: {__name__: http_request_duration_seconds_count}
+  expected_labels: {__name__: http_request_duration_seconds_count}
+`))
+	require.NoError(t, err)
+
+	for _, result := range relabeltest.Run(relabelConfig, sharding.Config{}, suite) {
+		assert.True(t, result.Passed(), "%s: %v", result.Case.Name, result)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+)
+
+// MetricType is a New Relic metric type set by the `newrelic_metric_type` label.
+type MetricType string
+
+const (
+	MetricTypeCounter MetricType = "counter"
+	MetricTypeGauge   MetricType = "gauge"
+	MetricTypeSummary MetricType = "summary"
+
+	// LatestCatalogVersion is the version of the catalog holding all the overrides.
+	LatestCatalogVersion = 1
+
+	metricTypeLabel = "newrelic_metric_type"
+)
+
+var ErrMetricType = errors.New("Metric Type Error")
+
+// MetricTypeOverrides sets the New Relic type of the metrics whose names match a pattern, for the exporters whose
+// metric types are not correctly detected. See:
+// <https://docs.newrelic.com/docs/infrastructure/prometheus-integrations/install-configure-remote-write/set-your-prometheus-remote-write-integration#override-mapping>
+type MetricTypeOverrides struct {
+	// CatalogVersion pins the version of the built-in catalog used by Exporters. Later versions may add overrides to
+	// an exporter, changing the type of metrics already sent, so upgrading is opt-in.
+	CatalogVersion int `yaml:"catalog_version"`
+	// Exporters enables the overrides of the catalog for each of the exporters, like `cockroach`.
+	Exporters []string `yaml:"exporters"`
+	// Overrides are user defined, they are applied after the ones of the catalog.
+	Overrides []MetricTypeOverride `yaml:"overrides"`
+}
+
+// MetricTypeOverride sets the type of the metrics whose name matches the Pattern regex.
+type MetricTypeOverride struct {
+	Pattern string     `yaml:"pattern"`
+	Type    MetricType `yaml:"type"`
+}
+
+// catalogEntry is an override of the catalog added in the since version.
+type catalogEntry struct {
+	exporter string
+	since    int
+	override MetricTypeOverride
+}
+
+// metricTypeCatalog holds the overrides of every catalog version. Entries are never modified nor removed, changes go
+// to new entries with the next catalog version.
+//
+//nolint:gochecknoglobals
+var metricTypeCatalog = []catalogEntry{
+	{exporter: "cockroach", since: 1, override: MetricTypeOverride{Pattern: "timeseries_write_(.*)", Type: MetricTypeCounter}},
+	{exporter: "cockroach", since: 1, override: MetricTypeOverride{Pattern: "sql_byte(.*)", Type: MetricTypeCounter}},
+}
+
+// CatalogExporters returns the exporters with overrides in the catalog version, sorted by name.
+func CatalogExporters(version int) []string {
+	var exporters []string
+
+	for _, entry := range metricTypeCatalog {
+		if entry.since <= version && !slices.Contains(exporters, entry.exporter) {
+			exporters = append(exporters, entry.exporter)
+		}
+	}
+
+	slices.Sort(exporters)
+
+	return exporters
+}
+
+// RelabelConfigs returns the write relabel rules setting the metric types, the ones of the catalog exporters first.
+func (m MetricTypeOverrides) RelabelConfigs() ([]promcfg.RelabelConfig, error) {
+	overrides, err := m.catalogOverrides()
+	if err != nil {
+		return nil, err
+	}
+
+	overrides = append(overrides, m.Overrides...)
+
+	var relabelConfigs []promcfg.RelabelConfig
+
+	for _, override := range overrides {
+		if err := override.validate(); err != nil {
+			return nil, err
+		}
+
+		relabelConfigs = append(relabelConfigs, promcfg.RelabelConfig{
+			SourceLabels: []string{"__name__"},
+			Separator:    ";",
+			Regex:        override.Pattern,
+			TargetLabel:  metricTypeLabel,
+			Replacement:  string(override.Type),
+			Action:       "replace",
+		})
+	}
+
+	return relabelConfigs, nil
+}
+
+// catalogOverrides returns the overrides of the enabled exporters in the catalog version, in catalog order.
+func (m MetricTypeOverrides) catalogOverrides() ([]MetricTypeOverride, error) {
+	if len(m.Exporters) == 0 {
+		return nil, nil
+	}
+
+	if m.CatalogVersion < 1 || m.CatalogVersion > LatestCatalogVersion {
+		return nil, fmt.Errorf("%w: The catalog_version must be set from 1 to %d to enable exporters", ErrMetricType, LatestCatalogVersion)
+	}
+
+	available := CatalogExporters(m.CatalogVersion)
+	for _, exporter := range m.Exporters {
+		if !slices.Contains(available, exporter) {
+			return nil, fmt.Errorf("%w: The exporter %q is not in the catalog version %d, the available ones are %s",
+				ErrMetricType, exporter, m.CatalogVersion, strings.Join(available, ", "))
+		}
+	}
+
+	var overrides []MetricTypeOverride
+
+	for _, entry := range metricTypeCatalog {
+		if entry.since <= m.CatalogVersion && slices.Contains(m.Exporters, entry.exporter) {
+			overrides = append(overrides, entry.override)
+		}
+	}
+
+	return overrides, nil
+}
+
+func (o MetricTypeOverride) validate() error {
+	if !slices.Contains([]MetricType{MetricTypeCounter, MetricTypeGauge, MetricTypeSummary}, o.Type) {
+		return fmt.Errorf("%w: The type %q of the pattern %q is not one of counter, gauge or summary", ErrMetricType, o.Type, o.Pattern)
+	}
+
+	if o.Pattern == "" {
+		return fmt.Errorf("%w: The pattern of the %s override is empty", ErrMetricType, o.Type)
+	}
+
+	if _, err := regexp.Compile(o.Pattern); err != nil {
+		return fmt.Errorf("%w: The pattern %q is not a valid regex: %w", ErrMetricType, o.Pattern, err)
+	}
+
+	return nil
+}
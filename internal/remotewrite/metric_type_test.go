@@ -0,0 +1,147 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package remotewrite_test
+
+import (
+	"testing"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/remotewrite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func metricTypeRule(regex string, metricType string) promcfg.RelabelConfig {
+	return promcfg.RelabelConfig{
+		SourceLabels: []string{"__name__"},
+		Separator:    ";",
+		Regex:        regex,
+		TargetLabel:  "newrelic_metric_type",
+		Replacement:  metricType,
+		Action:       "replace",
+	}
+}
+
+func TestMetricTypeOverrides(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name     string
+		Config   remotewrite.MetricTypeOverrides
+		Expected []promcfg.RelabelConfig
+	}{
+		{
+			Name:     "none",
+			Expected: nil,
+		},
+		{
+			Name:   "catalog exporter",
+			Config: remotewrite.MetricTypeOverrides{CatalogVersion: 1, Exporters: []string{"cockroach"}},
+			Expected: []promcfg.RelabelConfig{
+				metricTypeRule("timeseries_write_(.*)", "counter"),
+				metricTypeRule("sql_byte(.*)", "counter"),
+			},
+		},
+		{
+			Name: "user overrides after the catalog",
+			Config: remotewrite.MetricTypeOverrides{
+				CatalogVersion: 1,
+				Exporters:      []string{"cockroach"},
+				Overrides: []remotewrite.MetricTypeOverride{
+					{Pattern: "foo_total", Type: remotewrite.MetricTypeCounter},
+					{Pattern: "bar_.+", Type: remotewrite.MetricTypeGauge},
+				},
+			},
+			Expected: []promcfg.RelabelConfig{
+				metricTypeRule("timeseries_write_(.*)", "counter"),
+				metricTypeRule("sql_byte(.*)", "counter"),
+				metricTypeRule("foo_total", "counter"),
+				metricTypeRule("bar_.+", "gauge"),
+			},
+		},
+		{
+			Name: "user overrides do not require a catalog version",
+			Config: remotewrite.MetricTypeOverrides{
+				Overrides: []remotewrite.MetricTypeOverride{{Pattern: "foo_total", Type: remotewrite.MetricTypeCounter}},
+			},
+			Expected: []promcfg.RelabelConfig{metricTypeRule("foo_total", "counter")},
+		},
+	}
+
+	for _, testCase := range cases {
+		c := testCase
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+
+			rules, err := c.Config.RelabelConfigs()
+			require.NoError(t, err)
+			assert.Equal(t, c.Expected, rules)
+		})
+	}
+}
+
+func TestMetricTypeOverridesErrors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name            string
+		Config          remotewrite.MetricTypeOverrides
+		ExpectedMessage string
+	}{
+		{
+			Name:            "missing catalog version",
+			Config:          remotewrite.MetricTypeOverrides{Exporters: []string{"cockroach"}},
+			ExpectedMessage: "Metric Type Error: The catalog_version must be set from 1 to 1 to enable exporters",
+		},
+		{
+			Name:            "future catalog version",
+			Config:          remotewrite.MetricTypeOverrides{CatalogVersion: remotewrite.LatestCatalogVersion + 1, Exporters: []string{"cockroach"}},
+			ExpectedMessage: "Metric Type Error: The catalog_version must be set from 1 to 1 to enable exporters",
+		},
+		{
+			Name:            "unknown exporter",
+			Config:          remotewrite.MetricTypeOverrides{CatalogVersion: 1, Exporters: []string{"cockroachdb"}},
+			ExpectedMessage: `Metric Type Error: The exporter "cockroachdb" is not in the catalog version 1, the available ones are cockroach`,
+		},
+		{
+			Name: "invalid type",
+			Config: remotewrite.MetricTypeOverrides{
+				Overrides: []remotewrite.MetricTypeOverride{{Pattern: "foo_total", Type: "histogram"}},
+			},
+			ExpectedMessage: `Metric Type Error: The type "histogram" of the pattern "foo_total" is not one of counter, gauge or summary`,
+		},
+		{
+			Name: "empty pattern",
+			Config: remotewrite.MetricTypeOverrides{
+				Overrides: []remotewrite.MetricTypeOverride{{Type: remotewrite.MetricTypeGauge}},
+			},
+			ExpectedMessage: "Metric Type Error: The pattern of the gauge override is empty",
+		},
+		{
+			Name: "invalid pattern",
+			Config: remotewrite.MetricTypeOverrides{
+				Overrides: []remotewrite.MetricTypeOverride{{Pattern: "foo_(", Type: remotewrite.MetricTypeGauge}},
+			},
+			ExpectedMessage: "Metric Type Error: The pattern \"foo_(\" is not a valid regex: error parsing regexp: missing closing ): `foo_(`",
+		},
+	}
+
+	for _, testCase := range cases {
+		c := testCase
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := c.Config.RelabelConfigs()
+			assert.ErrorIs(t, err, remotewrite.ErrMetricType)
+			assert.EqualError(t, err, c.ExpectedMessage)
+		})
+	}
+}
+
+func TestCatalogExporters(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, remotewrite.CatalogExporters(0))
+	assert.Equal(t, []string{"cockroach"}, remotewrite.CatalogExporters(remotewrite.LatestCatalogVersion))
+}
@@ -0,0 +1,238 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+)
+
+const (
+	// includeLabel flags the metrics matching any of the include matchers when they need several rules.
+	includeLabel = "__tmp_nr_include"
+	metricName   = "__name__"
+)
+
+var ErrMetricsFilter = errors.New("Metrics Filter Error")
+
+// MetricsFilter selects the metrics sent to New Relic. When Include is set only the metrics matching any of its
+// matchers are sent, and the ones matching any of the Exclude matchers are never sent.
+type MetricsFilter struct {
+	Include []MetricMatcher `yaml:"include"`
+	Exclude []MetricMatcher `yaml:"exclude"`
+}
+
+// MetricMatcher matches the metrics whose name matches Name or NameRegex and whose labels match all the Labels.
+type MetricMatcher struct {
+	// Name is a glob of the metric name, where `*` matches any sequence of characters and `?` any character.
+	Name string `yaml:"name"`
+	// NameRegex is a regex of the whole metric name, it cannot be set along with Name.
+	NameRegex string `yaml:"name_regex"`
+	// Labels holds a regex of the whole value for each label.
+	Labels map[string]string `yaml:"labels"`
+}
+
+// FilterRule is a write relabel rule of the metrics filter along with the matchers it implements.
+type FilterRule struct {
+	Rule promcfg.RelabelConfig
+	// Covers describes the matchers implemented by the rule, like `exclude[0] name go_*`, empty for the rules
+	// handling the include flag.
+	Covers []string
+}
+
+// String describes the matcher, like `name http_* with job=~"api"`.
+func (m MetricMatcher) String() string {
+	var s string
+
+	switch {
+	case m.Name != "":
+		s = "name " + m.Name
+	case m.NameRegex != "":
+		s = "name_regex " + m.NameRegex
+	default:
+		s = "any name"
+	}
+
+	if len(m.Labels) == 0 {
+		return s
+	}
+
+	matchers := make([]string, 0, len(m.Labels))
+	for _, label := range slices.Sorted(maps.Keys(m.Labels)) {
+		matchers = append(matchers, fmt.Sprintf("%s=~%q", label, m.Labels[label]))
+	}
+
+	return s + " with " + strings.Join(matchers, ", ")
+}
+
+// RelabelConfigs returns the write relabel rules implementing the filter.
+func (f MetricsFilter) RelabelConfigs() ([]promcfg.RelabelConfig, error) {
+	rules, err := f.Rules()
+	if err != nil {
+		return nil, err
+	}
+
+	var relabelConfigs []promcfg.RelabelConfig
+	for _, rule := range rules {
+		relabelConfigs = append(relabelConfigs, rule.Rule)
+	}
+
+	return relabelConfigs, nil
+}
+
+// Rules returns the write relabel rules implementing the filter. Matchers on the same labels share a single rule
+// alternating their regexes, so the includes only need a keep rule when all of them are on the same labels. Otherwise
+// the metrics matching any of them are flagged in a temporary label, kept by it and the label is removed.
+func (f MetricsFilter) Rules() ([]FilterRule, error) {
+	includes, err := groupMatchers("include", f.Include)
+	if err != nil {
+		return nil, err
+	}
+
+	excludes, err := groupMatchers("exclude", f.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []FilterRule
+
+	switch len(includes) {
+	case 0:
+	case 1:
+		rules = append(rules, includes[0].rule("keep"))
+	default:
+		for _, group := range includes {
+			rule := group.rule("replace")
+			rule.Rule.TargetLabel = includeLabel
+			rule.Rule.Replacement = "true"
+			rules = append(rules, rule)
+		}
+
+		rules = append(rules,
+			FilterRule{Rule: promcfg.RelabelConfig{SourceLabels: []string{includeLabel}, Regex: "true", Action: "keep"}},
+			FilterRule{Rule: promcfg.RelabelConfig{Regex: includeLabel, Action: "labeldrop"}},
+		)
+	}
+
+	for _, group := range excludes {
+		rules = append(rules, group.rule("drop"))
+	}
+
+	return rules, nil
+}
+
+// matcherGroup holds the matchers on the same labels.
+type matcherGroup struct {
+	sourceLabels []string
+	regexes      []string
+	covers       []string
+}
+
+func (g matcherGroup) rule(action string) FilterRule {
+	regex := g.regexes[0]
+	if len(g.regexes) > 1 {
+		regex = "(?:" + strings.Join(g.regexes, ")|(?:") + ")"
+	}
+
+	rule := promcfg.RelabelConfig{SourceLabels: g.sourceLabels, Regex: regex, Action: action}
+	if len(g.sourceLabels) > 1 {
+		rule.Separator = ";"
+	}
+
+	return FilterRule{Rule: rule, Covers: g.covers}
+}
+
+// groupMatchers groups the matchers by the labels they match, in the order of their first matcher.
+func groupMatchers(list string, matchers []MetricMatcher) ([]*matcherGroup, error) {
+	var groups []*matcherGroup
+
+	byLabels := map[string]*matcherGroup{}
+
+	for i, matcher := range matchers {
+		sourceLabels, regex, err := matcher.compile()
+		if err != nil {
+			return nil, fmt.Errorf("%w: The matcher %s[%d] %w", ErrMetricsFilter, list, i, err)
+		}
+
+		key := strings.Join(sourceLabels, ";")
+
+		group, ok := byLabels[key]
+		if !ok {
+			group = &matcherGroup{sourceLabels: sourceLabels}
+			byLabels[key] = group
+			groups = append(groups, group)
+		}
+
+		group.regexes = append(group.regexes, regex)
+		group.covers = append(group.covers, fmt.Sprintf("%s[%d] %s", list, i, matcher))
+	}
+
+	return groups, nil
+}
+
+// compile returns the labels matched, the name first, and the regex matching their values joined by `;`.
+func (m MetricMatcher) compile() ([]string, string, error) {
+	if m.Name != "" && m.NameRegex != "" {
+		return nil, "", errors.New("sets both name and name_regex")
+	}
+
+	if m.Name == "" && m.NameRegex == "" && len(m.Labels) == 0 {
+		return nil, "", errors.New("is empty, it would match every metric")
+	}
+
+	var sourceLabels, regexes []string
+
+	switch {
+	case m.Name != "":
+		sourceLabels = append(sourceLabels, metricName)
+		regexes = append(regexes, globRegex(m.Name))
+	case m.NameRegex != "":
+		if _, err := regexp.Compile(m.NameRegex); err != nil {
+			return nil, "", fmt.Errorf("has an invalid name_regex: %w", err)
+		}
+
+		sourceLabels = append(sourceLabels, metricName)
+		regexes = append(regexes, "(?:"+m.NameRegex+")")
+	}
+
+	for _, label := range slices.Sorted(maps.Keys(m.Labels)) {
+		if _, err := regexp.Compile(m.Labels[label]); err != nil {
+			return nil, "", fmt.Errorf("has an invalid regex for the label %s: %w", label, err)
+		}
+
+		sourceLabels = append(sourceLabels, label)
+		regexes = append(regexes, "(?:"+m.Labels[label]+")")
+	}
+
+	if len(regexes) == 1 && m.Name == "" {
+		// A single regex does not need to be grouped.
+		return sourceLabels, strings.TrimSuffix(strings.TrimPrefix(regexes[0], "(?:"), ")"), nil
+	}
+
+	return sourceLabels, strings.Join(regexes, ";"), nil
+}
+
+// globRegex returns the regex matching the glob, where `*` matches any sequence of characters and `?` any character.
+func globRegex(glob string) string {
+	var sb strings.Builder
+
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	return sb.String()
+}
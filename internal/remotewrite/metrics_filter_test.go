@@ -0,0 +1,232 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package remotewrite_test
+
+import (
+	"testing"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/relabeling"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/relabeltest"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/remotewrite"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/sharding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMetricsFilterRules(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name     string
+		Filter   remotewrite.MetricsFilter
+		Expected []remotewrite.FilterRule
+	}{
+		{
+			Name:     "empty",
+			Filter:   remotewrite.MetricsFilter{},
+			Expected: nil,
+		},
+		{
+			Name: "names are merged in a single rule",
+			Filter: remotewrite.MetricsFilter{
+				Include: []remotewrite.MetricMatcher{{Name: "http_*"}, {NameRegex: "node_(cpu|memory)_.+"}},
+				Exclude: []remotewrite.MetricMatcher{{Name: "go_gc_?"}, {Name: "*.bucket"}},
+			},
+			Expected: []remotewrite.FilterRule{
+				{
+					Rule: promcfg.RelabelConfig{
+						SourceLabels: []string{"__name__"},
+						Regex:        "(?:http_.*)|(?:node_(cpu|memory)_.+)",
+						Action:       "keep",
+					},
+					Covers: []string{"include[0] name http_*", "include[1] name_regex node_(cpu|memory)_.+"},
+				},
+				{
+					Rule: promcfg.RelabelConfig{
+						SourceLabels: []string{"__name__"},
+						Regex:        `(?:go_gc_.)|(?:.*\.bucket)`,
+						Action:       "drop",
+					},
+					Covers: []string{"exclude[0] name go_gc_?", "exclude[1] name *.bucket"},
+				},
+			},
+		},
+		{
+			Name: "matchers on the same labels share the rule",
+			Filter: remotewrite.MetricsFilter{
+				Exclude: []remotewrite.MetricMatcher{
+					{Name: "http_*", Labels: map[string]string{"job": "api"}},
+					{Labels: map[string]string{"namespace": "kube-system"}},
+					{NameRegex: "grpc_.+", Labels: map[string]string{"job": "api|web"}},
+				},
+			},
+			Expected: []remotewrite.FilterRule{
+				{
+					Rule: promcfg.RelabelConfig{
+						SourceLabels: []string{"__name__", "job"},
+						Separator:    ";",
+						Regex:        "(?:http_.*;(?:api))|(?:(?:grpc_.+);(?:api|web))",
+						Action:       "drop",
+					},
+					Covers: []string{`exclude[0] name http_* with job=~"api"`, `exclude[2] name_regex grpc_.+ with job=~"api|web"`},
+				},
+				{
+					Rule: promcfg.RelabelConfig{
+						SourceLabels: []string{"namespace"},
+						Regex:        "kube-system",
+						Action:       "drop",
+					},
+					Covers: []string{`exclude[1] any name with namespace=~"kube-system"`},
+				},
+			},
+		},
+		{
+			Name: "includes on different labels are flagged",
+			Filter: remotewrite.MetricsFilter{
+				Include: []remotewrite.MetricMatcher{
+					{Name: "http_*"},
+					{Name: "*", Labels: map[string]string{"team": "payments"}},
+				},
+			},
+			Expected: []remotewrite.FilterRule{
+				{
+					Rule: promcfg.RelabelConfig{
+						SourceLabels: []string{"__name__"},
+						Regex:        "http_.*",
+						TargetLabel:  "__tmp_nr_include",
+						Replacement:  "true",
+						Action:       "replace",
+					},
+					Covers: []string{"include[0] name http_*"},
+				},
+				{
+					Rule: promcfg.RelabelConfig{
+						SourceLabels: []string{"__name__", "team"},
+						Separator:    ";",
+						Regex:        ".*;(?:payments)",
+						TargetLabel:  "__tmp_nr_include",
+						Replacement:  "true",
+						Action:       "replace",
+					},
+					Covers: []string{`include[1] name * with team=~"payments"`},
+				},
+				{Rule: promcfg.RelabelConfig{SourceLabels: []string{"__tmp_nr_include"}, Regex: "true", Action: "keep"}},
+				{Rule: promcfg.RelabelConfig{Regex: "__tmp_nr_include", Action: "labeldrop"}},
+			},
+		},
+	}
+
+	for _, testCase := range cases {
+		c := testCase
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+
+			rules, err := c.Filter.Rules()
+			require.NoError(t, err)
+			assert.Equal(t, c.Expected, rules)
+		})
+	}
+}
+
+func TestMetricsFilterErrors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name            string
+		Filter          remotewrite.MetricsFilter
+		ExpectedMessage string
+	}{
+		{
+			Name:            "name and name_regex",
+			Filter:          remotewrite.MetricsFilter{Include: []remotewrite.MetricMatcher{{Name: "http_*", NameRegex: "http_.*"}}},
+			ExpectedMessage: "Metrics Filter Error: The matcher include[0] sets both name and name_regex",
+		},
+		{
+			Name:            "empty matcher",
+			Filter:          remotewrite.MetricsFilter{Exclude: []remotewrite.MetricMatcher{{Name: "go_*"}, {}}},
+			ExpectedMessage: "Metrics Filter Error: The matcher exclude[1] is empty, it would match every metric",
+		},
+		{
+			Name:   "invalid name_regex",
+			Filter: remotewrite.MetricsFilter{Include: []remotewrite.MetricMatcher{{NameRegex: "http_(.*"}}},
+			ExpectedMessage: "Metrics Filter Error: The matcher include[0] has an invalid name_regex: " +
+				"error parsing regexp: missing closing ): `http_(.*`",
+		},
+		{
+			Name:   "invalid label regex",
+			Filter: remotewrite.MetricsFilter{Exclude: []remotewrite.MetricMatcher{{Labels: map[string]string{"job": "(api"}}}},
+			ExpectedMessage: "Metrics Filter Error: The matcher exclude[0] has an invalid regex for the label job: " +
+				"error parsing regexp: missing closing ): `(api`",
+		},
+	}
+
+	for _, testCase := range cases {
+		c := testCase
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := c.Filter.Rules()
+			assert.ErrorIs(t, err, remotewrite.ErrMetricsFilter)
+			assert.EqualError(t, err, c.ExpectedMessage)
+		})
+	}
+}
+
+func TestMetricsFilterSelectsMetrics(t *testing.T) {
+	t.Parallel()
+
+	config := remotewrite.Config{
+		LicenseKey: "fake",
+		Metrics: remotewrite.MetricsFilter{
+			Include: []remotewrite.MetricMatcher{
+				{Name: "http_*"},
+				{Name: "*", Labels: map[string]string{"team": "payments"}},
+			},
+			Exclude: []remotewrite.MetricMatcher{
+				{Name: "*_bucket"},
+				{Name: "http_requests_total", Labels: map[string]string{"code": "2.."}},
+			},
+		},
+	}
+
+	rw, err := config.Build()
+	require.NoError(t, err)
+
+	data, err := yaml.Marshal(map[string]any{"remote_write": []promcfg.RemoteWrite{rw}})
+	require.NoError(t, err)
+
+	relabelConfig, err := relabeling.Load(data)
+	require.NoError(t, err)
+
+	suite, err := relabeltest.LoadSuite([]byte(`
+tests:
+- name: included names are kept without the flag
+  stage: write_relabel
+  input_labels: {__name__: http_requests_total, code: "500"}
+  expected_labels: {__name__: http_requests_total, code: "500"}
+- name: included labels are kept
+  stage: write_relabel
+  input_labels: {__name__: payments_total, team: payments}
+  expected_labels: {__name__: payments_total, team: payments}
+- name: metrics not included are dropped
+  stage: write_relabel
+  input_labels: {__name__: go_goroutines, team: web}
+  expect_dropped: true
+- name: excluded names are dropped
+  stage: write_relabel
+  input_labels: {__name__: http_request_duration_seconds_bucket, le: "0.5"}
+  expect_dropped: true
+- name: excluded labels are dropped
+  stage: write_relabel
+  input_labels: {__name__: http_requests_total, code: "200"}
+  expect_dropped: true
+`))
+	require.NoError(t, err)
+
+	for _, result := range relabeltest.Run(relabelConfig, sharding.Config{}, suite) {
+		assert.True(t, result.Passed(), "%s: %v", result.Case.Name, result)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+)
+
+// QueueProfile selects a preset of the remote write queue config sized for an expected throughput.
+type QueueProfile string
+
+const (
+	// QueueProfileSmall sends up to 10k samples per second.
+	QueueProfileSmall QueueProfile = "small"
+	// QueueProfileMedium sends up to 100k samples per second, it matches the Prometheus defaults.
+	QueueProfileMedium QueueProfile = "medium"
+	// QueueProfileLarge sends up to 1M samples per second.
+	QueueProfileLarge QueueProfile = "large"
+	// QueueProfileCustom uses the queue config as it is.
+	QueueProfileCustom QueueProfile = "custom"
+)
+
+var ErrQueueProfile = errors.New("Queue Profile Error")
+
+// queuePresets are sorted by throughput. The capacity holds 5 batches per shard as recommended by Prometheus.
+//
+//nolint:gochecknoglobals
+var queuePresets = []struct {
+	profile QueueProfile
+	config  promcfg.QueueConfig
+}{
+	{
+		profile: QueueProfileSmall,
+		config: promcfg.QueueConfig{
+			Capacity:          5000,
+			MaxShards:         10,
+			MinShards:         1,
+			MaxSamplesPerSend: 1000,
+			BatchSendDeadLine: 5 * time.Second,
+			MinBackoff:        30 * time.Millisecond,
+			MaxBackoff:        5 * time.Second,
+		},
+	},
+	{
+		profile: QueueProfileMedium,
+		config: promcfg.QueueConfig{
+			Capacity:          10000,
+			MaxShards:         50,
+			MinShards:         1,
+			MaxSamplesPerSend: 2000,
+			BatchSendDeadLine: 5 * time.Second,
+			MinBackoff:        30 * time.Millisecond,
+			MaxBackoff:        5 * time.Second,
+		},
+	},
+	{
+		profile: QueueProfileLarge,
+		config: promcfg.QueueConfig{
+			Capacity:          25000,
+			MaxShards:         200,
+			MinShards:         4,
+			MaxSamplesPerSend: 5000,
+			BatchSendDeadLine: 5 * time.Second,
+			MinBackoff:        30 * time.Millisecond,
+			MaxBackoff:        5 * time.Second,
+		},
+	},
+}
+
+// queueThroughput is the samples per second a queue config sends, assuming each shard sends a batch per second.
+func queueThroughput(config promcfg.QueueConfig) int {
+	return config.MaxShards * config.MaxSamplesPerSend
+}
+
+// queueConfig returns the queue config of the profile, or the one selected by the estimated samples per second if
+// the profile is not set, with the fields set in the QueueConfig overriding the preset.
+func (c Config) queueConfig() (*promcfg.QueueConfig, error) {
+	profile := c.QueueProfile
+
+	if c.EstimatedSamplesPerSecond < 0 {
+		return nil, fmt.Errorf("%w: The estimated_samples_per_second cannot be negative", ErrQueueProfile)
+	}
+
+	if profile == QueueProfileCustom && c.EstimatedSamplesPerSecond > 0 {
+		return nil, fmt.Errorf("%w: The estimated_samples_per_second cannot be set along with the custom profile", ErrQueueProfile)
+	}
+
+	if profile == "" && c.EstimatedSamplesPerSecond > 0 {
+		profile = profileForThroughput(c.EstimatedSamplesPerSecond)
+	}
+
+	if profile == "" || profile == QueueProfileCustom {
+		return c.QueueConfig, nil
+	}
+
+	for _, preset := range queuePresets {
+		if preset.profile != profile {
+			continue
+		}
+
+		if throughput := queueThroughput(preset.config); c.EstimatedSamplesPerSecond > throughput {
+			return nil, fmt.Errorf("%w: The estimated_samples_per_second %d exceeds the %d samples per second of the %s profile",
+				ErrQueueProfile, c.EstimatedSamplesPerSecond, throughput, profile)
+		}
+
+		config := preset.config
+		if c.QueueConfig != nil {
+			overrideQueueConfig(&config, *c.QueueConfig)
+		}
+
+		return &config, nil
+	}
+
+	return nil, fmt.Errorf("%w: The queue_profile %q is not one of small, medium, large or custom", ErrQueueProfile, profile)
+}
+
+// profileForThroughput returns the smallest profile sending the samples per second, the largest one if none does so
+// the estimate is reported as exceeding it.
+func profileForThroughput(samplesPerSecond int) QueueProfile {
+	for _, preset := range queuePresets {
+		if samplesPerSecond <= queueThroughput(preset.config) {
+			return preset.profile
+		}
+	}
+
+	return queuePresets[len(queuePresets)-1].profile
+}
+
+// overrideQueueConfig sets in the config the fields set in the overrides.
+func overrideQueueConfig(config *promcfg.QueueConfig, overrides promcfg.QueueConfig) {
+	if overrides.Capacity != 0 {
+		config.Capacity = overrides.Capacity
+	}
+
+	if overrides.MaxShards != 0 {
+		config.MaxShards = overrides.MaxShards
+	}
+
+	if overrides.MinShards != 0 {
+		config.MinShards = overrides.MinShards
+	}
+
+	if overrides.MaxSamplesPerSend != 0 {
+		config.MaxSamplesPerSend = overrides.MaxSamplesPerSend
+	}
+
+	if overrides.BatchSendDeadLine != 0 {
+		config.BatchSendDeadLine = overrides.BatchSendDeadLine
+	}
+
+	if overrides.MinBackoff != 0 {
+		config.MinBackoff = overrides.MinBackoff
+	}
+
+	if overrides.MaxBackoff != 0 {
+		config.MaxBackoff = overrides.MaxBackoff
+	}
+
+	if overrides.RetryOnHTTP429 != nil {
+		config.RetryOnHTTP429 = overrides.RetryOnHTTP429
+	}
+
+	if overrides.SampleAgeLimit != 0 {
+		config.SampleAgeLimit = overrides.SampleAgeLimit
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package remotewrite_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/remotewrite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestQueueProfile(t *testing.T) {
+	t.Parallel()
+
+	retry := false
+
+	small := promcfg.QueueConfig{
+		Capacity:          5000,
+		MaxShards:         10,
+		MinShards:         1,
+		MaxSamplesPerSend: 1000,
+		BatchSendDeadLine: 5 * time.Second,
+		MinBackoff:        30 * time.Millisecond,
+		MaxBackoff:        5 * time.Second,
+	}
+
+	cases := []struct {
+		Name     string
+		Config   remotewrite.Config
+		Expected *promcfg.QueueConfig
+	}{
+		{
+			Name:     "no profile nor queue config",
+			Config:   remotewrite.Config{},
+			Expected: nil,
+		},
+		{
+			Name:     "no profile keeps the queue config",
+			Config:   remotewrite.Config{QueueConfig: &promcfg.QueueConfig{MaxShards: 3}},
+			Expected: &promcfg.QueueConfig{MaxShards: 3},
+		},
+		{
+			Name: "custom keeps the queue config",
+			Config: remotewrite.Config{
+				QueueProfile: remotewrite.QueueProfileCustom,
+				QueueConfig:  &promcfg.QueueConfig{MaxShards: 3},
+			},
+			Expected: &promcfg.QueueConfig{MaxShards: 3},
+		},
+		{
+			Name:     "small",
+			Config:   remotewrite.Config{QueueProfile: remotewrite.QueueProfileSmall},
+			Expected: &small,
+		},
+		{
+			Name: "queue config overrides the preset",
+			Config: remotewrite.Config{
+				QueueProfile: remotewrite.QueueProfileSmall,
+				QueueConfig:  &promcfg.QueueConfig{MaxShards: 20, RetryOnHTTP429: &retry},
+			},
+			Expected: &promcfg.QueueConfig{
+				Capacity:          5000,
+				MaxShards:         20,
+				MinShards:         1,
+				MaxSamplesPerSend: 1000,
+				BatchSendDeadLine: 5 * time.Second,
+				MinBackoff:        30 * time.Millisecond,
+				MaxBackoff:        5 * time.Second,
+				RetryOnHTTP429:    &retry,
+			},
+		},
+		{
+			Name:     "estimate selects the profile",
+			Config:   remotewrite.Config{EstimatedSamplesPerSecond: 8000},
+			Expected: &small,
+		},
+	}
+
+	for _, testCase := range cases {
+		c := testCase
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+
+			c.Config.LicenseKey = "fake"
+
+			rw, err := c.Config.Build()
+			require.NoError(t, err)
+			assert.Equal(t, c.Expected, rw.QueueConfig)
+		})
+	}
+}
+
+func TestQueueProfileErrors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name            string
+		Config          remotewrite.Config
+		ExpectedMessage string
+	}{
+		{
+			Name:            "unknown profile",
+			Config:          remotewrite.Config{QueueProfile: "huge"},
+			ExpectedMessage: `Queue Profile Error: The queue_profile "huge" is not one of small, medium, large or custom`,
+		},
+		{
+			Name:            "estimate with custom",
+			Config:          remotewrite.Config{QueueProfile: remotewrite.QueueProfileCustom, EstimatedSamplesPerSecond: 100},
+			ExpectedMessage: "Queue Profile Error: The estimated_samples_per_second cannot be set along with the custom profile",
+		},
+		{
+			Name:            "estimate exceeding the profile",
+			Config:          remotewrite.Config{QueueProfile: remotewrite.QueueProfileSmall, EstimatedSamplesPerSecond: 50000},
+			ExpectedMessage: "Queue Profile Error: The estimated_samples_per_second 50000 exceeds the 10000 samples per second of the small profile",
+		},
+		{
+			Name:            "estimate exceeding every profile",
+			Config:          remotewrite.Config{EstimatedSamplesPerSecond: 5000000},
+			ExpectedMessage: "Queue Profile Error: The estimated_samples_per_second 5000000 exceeds the 1000000 samples per second of the large profile",
+		},
+		{
+			Name:            "negative estimate",
+			Config:          remotewrite.Config{EstimatedSamplesPerSecond: -1},
+			ExpectedMessage: "Queue Profile Error: The estimated_samples_per_second cannot be negative",
+		},
+	}
+
+	for _, testCase := range cases {
+		c := testCase
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+
+			c.Config.LicenseKey = "fake"
+
+			_, err := c.Config.Build()
+			assert.ErrorIs(t, err, remotewrite.ErrQueueProfile)
+			assert.EqualError(t, err, c.ExpectedMessage)
+		})
+	}
+}
+
+func TestQueueConfigOmitsUnsetFields(t *testing.T) {
+	t.Parallel()
+
+	data, err := yaml.Marshal(promcfg.QueueConfig{MaxShards: 10})
+	require.NoError(t, err)
+	assert.Equal(t, "max_shards: 10\n", string(data))
+}
@@ -28,19 +28,38 @@ const (
 	collectorVersionQueryParam = "collector_version"
 	legacyCollectionDomain     = "newrelic.com"
 	collectionDomain           = "nr-data.net"
+	// defaultRegion is the region of license keys without a region prefix, it has no region in the endpoint host.
+	defaultRegion = "us"
+	// fedRAMPLicenseRegion is the prefix of FedRAMP license keys, which belong to the default region.
+	fedRAMPLicenseRegion = "gov"
+	// metricAPIHost is part of the host of every New Relic metric API endpoint.
+	metricAPIHost = "metric-api."
 )
 
 var (
 	ErrFedRAMPRegions = errors.New("FedRAMP Region Error")
+	ErrRegion         = errors.New("Region Error")
+	ErrEndpoint       = errors.New("Endpoint Error")
 )
 
+// regionRegex matches the region codes, like the prefixes of the license keys.
+var regionRegex = regexp.MustCompile(`^[a-z]{2,3}$`) //nolint: gochecknoglobals
+
 type URLOption func(url *URL)
 
 type URL struct {
 	Staging bool
 	FedRAMP bool
-	Region  string
-	Values  url.Values
+	// Region is the region configured explicitly, used when the license key does not hold one.
+	Region string
+	// LicenseRegion is the region of the license key, the default one for keys without a region prefix. It is empty
+	// when no license key is set.
+	LicenseRegion string
+	// FedRAMPLicense is set when the license key is a FedRAMP one, only valid for the FedRAMP endpoints.
+	FedRAMPLicense bool
+	// Endpoint replaces the New Relic endpoint, like a private link or a proxy.
+	Endpoint string
+	Values   url.Values
 }
 
 func NewURL(opts ...URLOption) *URL {
@@ -83,14 +102,41 @@ func licenseGetRegion(licenseKey string) string {
 	return ""
 }
 
+// WithLicense sets the region of the license key: the one in its prefix, or the default one for keys without prefix and
+// FedRAMP keys, which are flagged as such. Nothing is set for an empty key.
 func WithLicense(license string) URLOption {
 	return func(u *URL) {
-		region := licenseGetRegion(license)
-		if region != "" && region != "gov" {
-			u.Region = region
-		} else {
-			u.Region = ""
+		u.LicenseRegion = ""
+		u.FedRAMPLicense = false
+
+		if license == "" {
+			return
 		}
+
+		switch region := licenseGetRegion(license); region {
+		case "":
+			u.LicenseRegion = defaultRegion
+		case fedRAMPLicenseRegion:
+			u.LicenseRegion = defaultRegion
+			u.FedRAMPLicense = true
+		default:
+			u.LicenseRegion = region
+		}
+	}
+}
+
+// WithRegion sets the region of the endpoint, `us` being the default one. It must match the region of the license
+// key, if any.
+func WithRegion(region string) URLOption {
+	return func(u *URL) {
+		u.Region = strings.ToLower(region)
+	}
+}
+
+// WithEndpoint replaces the New Relic endpoint by the provided URL, the query parameters are added to it.
+func WithEndpoint(endpoint string) URLOption {
+	return func(u *URL) {
+		u.Endpoint = endpoint
 	}
 }
 
@@ -136,35 +182,118 @@ func WithCollectorVersion(collectorVersion string) URLOption {
 	}
 }
 
+// region returns the region of the endpoint, empty for the default one.
+func (u *URL) region() string {
+	region := u.LicenseRegion
+	if region == "" {
+		region = u.Region
+	}
+
+	if region == defaultRegion {
+		return ""
+	}
+
+	return region
+}
+
 func (u *URL) preconditions() error {
+	if u.Region != "" && !regionRegex.MatchString(u.Region) {
+		return fmt.Errorf("%w: %q is not a valid region, it must be a region code like us or eu", ErrRegion, u.Region)
+	}
+	if u.Region != "" && u.LicenseRegion != "" && u.Region != u.LicenseRegion {
+		return fmt.Errorf("%w: The region %s does not match the region %s of the license key", ErrRegion, u.Region, u.LicenseRegion)
+	}
+	if u.FedRAMPLicense && !u.FedRAMP {
+		return fmt.Errorf("%w: The license key is a FedRAMP one, FedRAMP must be enabled", ErrFedRAMPRegions)
+	}
 	if u.Staging && u.FedRAMP {
 		return fmt.Errorf("%w: There is no FedRamp compatible endpoints for staging", ErrFedRAMPRegions)
 	}
-	if u.Region != "" && u.FedRAMP {
-		return fmt.Errorf("%w: There is no FedRamp compatible endpoints for the region %s", ErrFedRAMPRegions, u.Region)
+	if u.region() != "" && u.FedRAMP {
+		return fmt.Errorf("%w: There is no FedRamp compatible endpoints for the region %s", ErrFedRAMPRegions, u.region())
+	}
+	if u.Endpoint != "" {
+		return u.endpointPreconditions()
 	}
 	return nil
 }
 
-func (u *URL) Build() (string, error) {
-	if err := u.preconditions(); err != nil {
-		return "", err
+// endpointPreconditions checks the endpoint override. New Relic metric API endpoints must be the one matching the
+// region, staging and FedRAMP settings, other hosts like proxies or test receivers are not checked, except that FedRAMP
+// and staging cannot point to them.
+func (u *URL) endpointPreconditions() error {
+	endpoint, err := url.Parse(u.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return fmt.Errorf("%w: The endpoint must be an absolute http or https URL", ErrEndpoint)
 	}
 
-	prefix := getPrefix(u.Staging, u.FedRAMP)
-	domain := getDomain(u.Region)
+	expectedHost := u.host()
+	host := endpoint.Hostname()
+
+	switch {
+	case host == expectedHost:
+		return nil
+	case strings.Contains(host, metricAPIHost) && isNewRelicHost(host):
+		return fmt.Errorf("%w: The endpoint host %s does not match %s, expected for the region and FedRAMP settings", ErrEndpoint, host, expectedHost)
+	case u.FedRAMP:
+		return fmt.Errorf("%w: FedRAMP requires the endpoint host to be %s", ErrEndpoint, expectedHost)
+	case u.Staging:
+		return fmt.Errorf("%w: Staging requires the endpoint host to be %s", ErrEndpoint, expectedHost)
+	}
+
+	return nil
+}
+
+func isNewRelicHost(host string) bool {
+	return strings.HasSuffix(host, "."+legacyCollectionDomain) || strings.HasSuffix(host, "."+collectionDomain)
+}
+
+// host returns the host of the New Relic endpoint.
+func (u *URL) host() string {
+	region := u.region()
 	regionPostfix := ""
 
-	if u.Region != "" {
+	if region != "" {
 		regionPostfix = "."
 	}
 
+	return fmt.Sprintf(remoteWriteHostTemplate, getPrefix(u.Staging, u.FedRAMP), region, regionPostfix, getDomain(region))
+}
+
+func (u *URL) Build() (string, error) {
+	if err := u.preconditions(); err != nil {
+		return "", err
+	}
+
+	if u.Endpoint != "" {
+		return u.buildEndpoint()
+	}
+
 	url := url.URL{
 		Scheme:   remoteWriteScheme,
-		Host:     fmt.Sprintf(remoteWriteHostTemplate, prefix, u.Region, regionPostfix, domain),
+		Host:     u.host(),
 		Path:     remoteWritePath,
 		RawQuery: u.Values.Encode(),
 	}
 
 	return url.String(), nil
 }
+
+// buildEndpoint returns the endpoint override with the query parameters added to the ones it already has.
+func (u *URL) buildEndpoint() (string, error) {
+	endpoint, err := url.Parse(u.Endpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrEndpoint, err)
+	}
+
+	values := endpoint.Query()
+	for key, keyValues := range u.Values {
+		for _, value := range keyValues {
+			values.Add(key, value)
+		}
+	}
+
+	endpoint.RawQuery = values.Encode()
+
+	return endpoint.String(), nil
+}
@@ -15,6 +15,8 @@ func TestRemoteWriteURL(t *testing.T) {
 		Staging          bool
 		FedRAMP          bool
 		LicenseKey       string
+		Region           string
+		Endpoint         string
 		Expected         string
 		DataSourceName   string
 		CollectorName    string
@@ -86,6 +88,50 @@ func TestRemoteWriteURL(t *testing.T) {
 			CollectorVersion: "1.0.0",
 			Expected:         "https://metric-api.newrelic.com/prometheus/v1/write?collector_version=1.0.0",
 		},
+		{
+			Name:     "explicit region",
+			Region:   "EU",
+			Expected: "https://metric-api.eu.newrelic.com/prometheus/v1/write",
+		},
+		{
+			Name:       "explicit default region",
+			LicenseKey: "non-eu-license-key",
+			Region:     "us",
+			Expected:   "https://metric-api.newrelic.com/prometheus/v1/write",
+		},
+		{
+			Name:       "explicit region matching the license",
+			LicenseKey: "jpx-license-key",
+			Region:     "jp",
+			Expected:   "https://metric-api.jp.nr-data.net/prometheus/v1/write",
+		},
+		{
+			Name:       "FedRAMP license",
+			FedRAMP:    true,
+			LicenseKey: "gov01xx-license-key",
+			Region:     "us",
+			Expected:   "https://gov-metric-api.newrelic.com/prometheus/v1/write",
+		},
+		{
+			Name:           "endpoint override",
+			LicenseKey:     "eu01xx-license-key",
+			Endpoint:       "http://receiver.local:9090/api/write?tenant=a",
+			DataSourceName: "source",
+			Expected:       "http://receiver.local:9090/api/write?prometheus_server=source&tenant=a",
+		},
+		{
+			Name:       "endpoint matching the region",
+			LicenseKey: "eu01xx-license-key",
+			Endpoint:   "https://metric-api.eu.newrelic.com/prometheus/v1/write",
+			Expected:   "https://metric-api.eu.newrelic.com/prometheus/v1/write",
+		},
+		{
+			Name:       "FedRAMP endpoint",
+			FedRAMP:    true,
+			LicenseKey: "non-eu-license-key",
+			Endpoint:   "https://gov-metric-api.newrelic.com/prometheus/v1/write",
+			Expected:   "https://gov-metric-api.newrelic.com/prometheus/v1/write",
+		},
 	}
 
 	for _, testCase := range cases {
@@ -95,6 +141,8 @@ func TestRemoteWriteURL(t *testing.T) {
 			rwu := remotewrite.NewURL(
 				remotewrite.WithFedRAMP(c.FedRAMP),
 				remotewrite.WithLicense(c.LicenseKey),
+				remotewrite.WithRegion(c.Region),
+				remotewrite.WithEndpoint(c.Endpoint),
 				remotewrite.WithStaging(c.Staging),
 				remotewrite.WithDataSourceName(c.DataSourceName),
 				remotewrite.WithCollectorName(c.CollectorName),
@@ -116,6 +164,8 @@ func TestRemoteWriteURLErrors(t *testing.T) {
 		Staging         bool
 		FedRAMP         bool
 		LicenseKey      string
+		Region          string
+		Endpoint        string
 		DataSourceName  string
 		ExpectedError   error
 		ExpectedMessage string
@@ -135,6 +185,75 @@ func TestRemoteWriteURLErrors(t *testing.T) {
 			ExpectedError:   remotewrite.ErrFedRAMPRegions,
 			ExpectedMessage: "FedRAMP Region Error: There is no FedRamp compatible endpoints for the region eu",
 		},
+		{
+			Name:            "Explicit European FedRAMP",
+			FedRAMP:         true,
+			Region:          "eu",
+			ExpectedError:   remotewrite.ErrFedRAMPRegions,
+			ExpectedMessage: "FedRAMP Region Error: There is no FedRamp compatible endpoints for the region eu",
+		},
+		{
+			Name:            "invalid region",
+			Region:          "europe-1",
+			ExpectedError:   remotewrite.ErrRegion,
+			ExpectedMessage: `Region Error: "europe-1" is not a valid region, it must be a region code like us or eu`,
+		},
+		{
+			Name:            "region not matching the license",
+			LicenseKey:      "eu01xx-license-key",
+			Region:          "jp",
+			ExpectedError:   remotewrite.ErrRegion,
+			ExpectedMessage: "Region Error: The region jp does not match the region eu of the license key",
+		},
+		{
+			Name:            "region not matching a license without prefix",
+			LicenseKey:      "non-eu-license-key",
+			Region:          "eu",
+			ExpectedError:   remotewrite.ErrRegion,
+			ExpectedMessage: "Region Error: The region eu does not match the region us of the license key",
+		},
+		{
+			Name:            "FedRAMP license without FedRAMP",
+			LicenseKey:      "gov01xx-license-key",
+			ExpectedError:   remotewrite.ErrFedRAMPRegions,
+			ExpectedMessage: "FedRAMP Region Error: The license key is a FedRAMP one, FedRAMP must be enabled",
+		},
+		{
+			Name:            "FedRAMP license with another region",
+			FedRAMP:         true,
+			LicenseKey:      "gov01xx-license-key",
+			Region:          "eu",
+			ExpectedError:   remotewrite.ErrRegion,
+			ExpectedMessage: "Region Error: The region eu does not match the region us of the license key",
+		},
+		{
+			Name:            "invalid endpoint",
+			Endpoint:        "metric-api.newrelic.com/prometheus/v1/write",
+			ExpectedError:   remotewrite.ErrEndpoint,
+			ExpectedMessage: "Endpoint Error: The endpoint must be an absolute http or https URL",
+		},
+		{
+			Name:          "endpoint not matching the region",
+			LicenseKey:    "eu01xx-license-key",
+			Endpoint:      "https://metric-api.newrelic.com/prometheus/v1/write",
+			ExpectedError: remotewrite.ErrEndpoint,
+			ExpectedMessage: "Endpoint Error: The endpoint host metric-api.newrelic.com does not match metric-api.eu.newrelic.com, " +
+				"expected for the region and FedRAMP settings",
+		},
+		{
+			Name:            "FedRAMP custom endpoint",
+			FedRAMP:         true,
+			Endpoint:        "https://receiver.local/write",
+			ExpectedError:   remotewrite.ErrEndpoint,
+			ExpectedMessage: "Endpoint Error: FedRAMP requires the endpoint host to be gov-metric-api.newrelic.com",
+		},
+		{
+			Name:            "staging custom endpoint",
+			Staging:         true,
+			Endpoint:        "https://receiver.local/write",
+			ExpectedError:   remotewrite.ErrEndpoint,
+			ExpectedMessage: "Endpoint Error: Staging requires the endpoint host to be staging-metric-api.newrelic.com",
+		},
 	}
 
 	for _, testCase := range cases {
@@ -144,6 +263,8 @@ func TestRemoteWriteURLErrors(t *testing.T) {
 			rwu := remotewrite.NewURL(
 				remotewrite.WithFedRAMP(c.FedRAMP),
 				remotewrite.WithLicense(c.LicenseKey),
+				remotewrite.WithRegion(c.Region),
+				remotewrite.WithEndpoint(c.Endpoint),
 				remotewrite.WithStaging(c.Staging),
 				remotewrite.WithDataSourceName(c.DataSourceName),
 			)
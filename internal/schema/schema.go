@@ -0,0 +1,162 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package schema generates the JSON Schema of the nrConfig from the yaml tags of its Go types, so editors and Helm can
+// validate configurations before they reach the configurator.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/alecthomas/units"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/configurator"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/yamlfields"
+)
+
+const (
+	draft = "https://json-schema.org/draft/2020-12/schema"
+
+	// durationPattern matches the durations accepted by the yaml library, like `30s` or `1m30s`.
+	durationPattern = `^(0|-?([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+)$`
+	// bytesPattern matches the sizes accepted by units.Base2Bytes, like `10MB` or `1GiB`.
+	bytesPattern = `^[0-9]+([KMGTPE]i?)?B$`
+)
+
+// Schema is the subset of a JSON Schema used to describe the nrConfig.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 any                `json:"type,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+}
+
+// NrConfig returns the schema of the nrConfig.
+func NrConfig() *Schema {
+	g := generator{defs: map[string]*Schema{}}
+	root := g.schemaOf(reflect.TypeFor[configurator.NrConfig]())
+
+	return &Schema{
+		Schema:      draft,
+		Title:       "New Relic Prometheus Configurator",
+		Description: "Configuration of the newrelic-prometheus-configurator, set in the `config` block of the chart values.",
+		Ref:         root.Ref,
+		Defs:        g.defs,
+	}
+}
+
+// chartConfig is the `config` block of the chart values, which holds besides the nrConfig some keys consumed by the
+// chart templates.
+type chartConfig struct {
+	configurator.NrConfig `yaml:",inline"`
+	ProxyFromSecret       proxyFromSecret `yaml:"proxyFromSecret"`
+	ExtraEnvs             []any           `yaml:"extraEnvs"`
+}
+
+type proxyFromSecret struct {
+	Enabled bool   `yaml:"enabled"`
+	Name    string `yaml:"name"`
+	Key     string `yaml:"key"`
+}
+
+// ChartValues returns the schema of the newrelic-prometheus-agent chart values validating only the `config` block,
+// to be used as `values.schema.json`.
+func ChartValues() *Schema {
+	g := generator{defs: map[string]*Schema{}}
+
+	return &Schema{
+		Schema: draft,
+		Title:  "newrelic-prometheus-agent chart values",
+		Type:   "object",
+		Properties: map[string]*Schema{
+			"config": g.schemaOf(reflect.TypeFor[chartConfig]()),
+		},
+		Defs: g.defs,
+	}
+}
+
+// Marshal returns the indented JSON representation of the schema.
+func Marshal(s *Schema) ([]byte, error) {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshaling schema: %w", err)
+	}
+
+	return append(data, '\n'), nil
+}
+
+// generator builds schemas adding a definition for each struct type, named after the Go type like
+// `kubernetes.Config`.
+type generator struct {
+	defs map[string]*Schema
+}
+
+func (g *generator) schemaOf(t reflect.Type) *Schema {
+	switch t {
+	case reflect.TypeFor[time.Duration]():
+		return &Schema{Type: "string", Pattern: durationPattern}
+	case reflect.TypeFor[units.Base2Bytes]():
+		return &Schema{AnyOf: []*Schema{{Type: "integer", Minimum: new(int)}, {Type: "string", Pattern: bytesPattern}}}
+	}
+
+	switch t.Kind() { //nolint: exhaustive
+	case reflect.Pointer:
+		return g.schemaOf(t.Elem())
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Minimum: new(int)}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	// The yaml library decodes any scalar into a string, like `prometheus.io/scrape: true` or a presence-only filter
+	// with no value.
+	case reflect.String:
+		return &Schema{Type: []string{"string", "boolean", "number", "null"}}
+	// Empty blocks are decoded as null, like a key having all its children commented out in the chart values.
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: []string{"array", "null"}, Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: []string{"object", "null"}, AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		return g.structRef(t)
+	default:
+		// Interfaces hold raw Prometheus config which is not validated.
+		return &Schema{}
+	}
+}
+
+func (g *generator) structRef(t reflect.Type) *Schema {
+	name := t.String()
+	ref := &Schema{Ref: "#/$defs/" + name}
+
+	if _, visited := g.defs[name]; visited {
+		return ref
+	}
+
+	def := &Schema{Type: []string{"object", "null"}, Properties: map[string]*Schema{}, AdditionalProperties: false}
+	// The definition is registered before walking the fields to support recursive types.
+	g.defs[name] = def
+	g.addProperties(def, t)
+
+	return ref
+}
+
+// addProperties adds the properties of the fields of the struct, the fields of inlined structs are added to the
+// struct including them since that is where the yaml library expects them.
+func (g *generator) addProperties(def *Schema, t reflect.Type) {
+	for _, field := range yamlfields.Of(t) {
+		def.Properties[field.Name] = g.schemaOf(field.Type)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package schema_test
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	publishedSchema = "../../schema/nr-config.schema.json"
+	chartValues     = "../../charts/newrelic-prometheus-agent/values.yaml"
+)
+
+// TestPublishedSchemaInSync fails when the yaml tags of the nrConfig types change without regenerating the published
+// schema with `make generate-schema`.
+func TestPublishedSchemaInSync(t *testing.T) {
+	t.Parallel()
+
+	published, err := os.ReadFile(publishedSchema)
+	require.NoError(t, err)
+
+	generated, err := schema.Marshal(schema.NrConfig())
+	require.NoError(t, err)
+
+	assert.Equal(t, string(published), string(generated), "the schema is outdated, run `make generate-schema`")
+}
+
+func TestNrConfig(t *testing.T) {
+	t.Parallel()
+
+	s := schema.NrConfig()
+	assert.Equal(t, "#/$defs/configurator.NrConfig", s.Ref)
+
+	root := s.Defs["configurator.NrConfig"]
+	require.NotNil(t, root)
+	assert.Equal(t, false, root.AdditionalProperties)
+	assert.Contains(t, root.Properties, "newrelic_remote_write")
+	assert.Equal(t, "#/$defs/remotewrite.Config", root.Properties["newrelic_remote_write"].Ref)
+
+	// Fields ignored by the yaml library are not part of the schema.
+	assert.NotContains(t, s.Defs["remotewrite.Config"].Properties, "ChartVersion")
+	assert.NotContains(t, s.Defs["remotewrite.Config"].Properties, "chartversion")
+
+	// The fields of promcfg.Job are inlined in the kubernetes and static target jobs.
+	for _, job := range []string{"kubernetes.K8sJob", "statictargets.StaticTargetJob"} {
+		properties := s.Defs[job].Properties
+		assert.Contains(t, properties, "job_name")
+		assert.Contains(t, properties, "scrape_interval")
+		assert.Contains(t, properties, "skip_sharding")
+		assert.Contains(t, properties, "extra_relabel_config")
+		assert.NotContains(t, s.Defs, "promcfg.Job")
+	}
+
+	// Any scalar is decoded into strings, like the values of the annotations filter.
+	annotations := s.Defs["kubernetes.Filter"].Properties["annotations"].AdditionalProperties
+	assert.Equal(t, []string{"string", "boolean", "number", "null"}, annotations.(*schema.Schema).Type)
+
+	scrapeInterval := s.Defs["promcfg.GlobalConfig"].Properties["scrape_interval"]
+	assert.Equal(t, "string", scrapeInterval.Type)
+	assert.Regexp(t, scrapeInterval.Pattern, "1m30s")
+	assert.NotRegexp(t, scrapeInterval.Pattern, "30")
+}
+
+func TestChartValues(t *testing.T) {
+	t.Parallel()
+
+	s := schema.ChartValues()
+	require.Contains(t, s.Properties, "config")
+	// Chart values other than config are not validated.
+	assert.Nil(t, s.AdditionalProperties)
+
+	config := s.Defs["schema.chartConfig"]
+	require.NotNil(t, config)
+	assert.Equal(t, "#/$defs/schema.chartConfig", s.Properties["config"].Ref)
+
+	// The config block holds the nrConfig and the keys consumed by the chart templates.
+	for name := range schema.NrConfig().Defs["configurator.NrConfig"].Properties {
+		assert.Contains(t, config.Properties, name)
+	}
+
+	assert.Contains(t, config.Properties, "proxyFromSecret")
+	assert.Contains(t, config.Properties, "extraEnvs")
+}
+
+func TestChartDefaultValuesAreValid(t *testing.T) {
+	t.Parallel()
+
+	data, err := os.ReadFile(chartValues)
+	require.NoError(t, err)
+
+	var values any
+	require.NoError(t, yaml.Unmarshal(data, &values))
+
+	s := schema.ChartValues()
+	assert.Empty(t, validate(s.Defs, s, values, "values"))
+
+	// The check is not vacuous, unknown keys and values of other types are rejected.
+	invalid := map[string]any{"config": map[string]any{"sharding": map[string]any{"total_shards_count": "two"}, "unknown": 1}}
+	assert.Len(t, validate(s.Defs, s, invalid, "values"), 2)
+}
+
+func TestConfigsWithScalarsAreValid(t *testing.T) {
+	t.Parallel()
+
+	data := []byte(`
+kubernetes:
+  jobs:
+  - job_name_prefix: presence
+    target_discovery:
+      pod: true
+      filter:
+        annotations:
+          prometheus.io/scrape: true
+          prometheus.io/port: 9090
+        labels:
+          app.kubernetes.io/component:
+`)
+
+	var values any
+	require.NoError(t, yaml.Unmarshal(data, &values))
+
+	s := schema.NrConfig()
+	assert.Empty(t, validate(s.Defs, s, values, "config"))
+}
+
+// validate returns the problems found validating the value against the subset of JSON Schema generated by the schema
+// package.
+func validate(defs map[string]*schema.Schema, s *schema.Schema, value any, path string) []string {
+	if s.Ref != "" {
+		return validate(defs, defs[strings.TrimPrefix(s.Ref, "#/$defs/")], value, path)
+	}
+
+	if len(s.AnyOf) > 0 {
+		for _, option := range s.AnyOf {
+			if len(validate(defs, option, value, path)) == 0 {
+				return nil
+			}
+		}
+
+		return []string{path + ": matches none of the options"}
+	}
+
+	if s.Type != nil && !matchesType(s.Type, value) {
+		return []string{fmt.Sprintf("%s: %v is not of type %v", path, value, s.Type)}
+	}
+
+	if str, ok := value.(string); ok && s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(str) {
+		return []string{fmt.Sprintf("%s: %q does not match %s", path, str, s.Pattern)}
+	}
+
+	var problems []string
+
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			childPath := path + "." + key
+
+			switch additional, _ := s.AdditionalProperties.(*schema.Schema); {
+			case s.Properties[key] != nil:
+				problems = append(problems, validate(defs, s.Properties[key], child, childPath)...)
+			case additional != nil:
+				problems = append(problems, validate(defs, additional, child, childPath)...)
+			case s.AdditionalProperties == false:
+				problems = append(problems, childPath+": unknown property")
+			}
+		}
+	case []any:
+		for i, child := range v {
+			if s.Items != nil {
+				problems = append(problems, validate(defs, s.Items, child, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case int:
+		if s.Minimum != nil && v < *s.Minimum {
+			problems = append(problems, fmt.Sprintf("%s: %d is lower than %d", path, v, *s.Minimum))
+		}
+	}
+
+	return problems
+}
+
+func matchesType(schemaType any, value any) bool {
+	types, ok := schemaType.([]string)
+	if !ok {
+		types = []string{schemaType.(string)}
+	}
+
+	for _, t := range types {
+		switch value.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case int:
+			if t == "integer" || t == "number" {
+				return true
+			}
+		case float64:
+			if t == "number" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case []any:
+			if t == "array" {
+				return true
+			}
+		case map[string]any:
+			if t == "object" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
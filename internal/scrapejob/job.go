@@ -29,10 +29,18 @@ func (j Job) WithName(name string) Job {
 	return j
 }
 
+// ShardingRules returns the sharding rules corresponding to the job configuration and the sharding config provided.
+func (j Job) ShardingRules(shardingConfig sharding.Config) []promcfg.RelabelConfig {
+	if shardingConfig.ShouldIncludeShardingRules() && !j.SkipSharding {
+		return shardingConfig.RelabelConfigs()
+	}
+	return nil
+}
+
 // Includes the sharding rules corresponding to the job configuration and the sharding config provided.
 func (j Job) includeShardingRules(shardingConfig sharding.Config, job promcfg.Job) promcfg.Job {
-	if shardingConfig.ShouldIncludeShardingRules() && !j.SkipSharding {
-		job.RelabelConfigs = append(shardingConfig.RelabelConfigs(), job.RelabelConfigs...)
+	if rules := j.ShardingRules(shardingConfig); rules != nil {
+		job.RelabelConfigs = append(rules, job.RelabelConfigs...)
 	}
 	return job
 }
@@ -2,40 +2,128 @@ package sharding
 
 import (
 	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
 )
 
+const (
+	// KindHash splits the targets among the shards by the hash of their HashSourceLabels, it is the default.
+	KindHash = "hash"
+	// KindNode makes each replica, like the pods of a DaemonSet, scrape only the Kubernetes targets of its node.
+	KindNode = "node"
+	// KindNamespace splits the targets by the hash of their Kubernetes namespace, so all the targets of a namespace
+	// are scraped by the same shard.
+	KindNamespace = "namespace"
+
+	namespaceLabel = "__meta_kubernetes_namespace"
+)
+
+// addressHostRegex captures the host of the address without the port, for bracketed IPv6 addresses like
+// `[2001:db8::1]:9090`, IPv4 addresses and hostnames like `10.0.0.1:8080`, or any other value as it is, like an IPv6
+// address without brackets nor port.
+const addressHostRegex = `\[([^\]]+)\](?::\d+)?|([^:]+)(?::\d+)?|(.+)`
+
 // Config defines all the NewRelic's sharding options.
 type Config struct {
 	Kind             string `yaml:"kind"`
 	TotalShardsCount int    `yaml:"total_shards_count"`
 	ShardIndex       string `yaml:"shard_index"`
+	// HashSourceLabels are the target labels whose values are hashed to select the shard, defaults to the host of the
+	// `__address__` without the port.
+	HashSourceLabels []string `yaml:"hash_source_labels"`
+	// NodeName is the node whose targets are scraped with the node kind.
+	NodeName string `yaml:"node_name"`
+	// PinnedNamespaces assigns namespaces to a shard instead of hashing them with the namespace kind, like the ones
+	// of very large tenants.
+	PinnedNamespaces map[string]int `yaml:"pinned_namespaces"`
+	// IncludeExtraScrapeConfigs adds the sharding rules to the extra scrape configs not setting `skip_sharding`, which
+	// are otherwise scraped by every shard.
+	IncludeExtraScrapeConfigs bool `yaml:"include_extra_scrape_configs"`
 }
 
 // ShouldIncludeShardingRules returns true when additional rules are needed for the current configuration.
 func (c Config) ShouldIncludeShardingRules() bool {
-	return c.TotalShardsCount > 1
+	return c.Kind != KindNode && c.TotalShardsCount > 1
+}
+
+// NodeLocal returns true when only the Kubernetes targets of the node are scraped.
+func (c Config) NodeLocal() bool {
+	return c.Kind == KindNode
 }
 
 func (c Config) RelabelConfigs() []promcfg.RelabelConfig {
-	return []promcfg.RelabelConfig{
-		{
+	var rules []promcfg.RelabelConfig
+
+	if len(c.HashSourceLabels) == 0 {
+		rules = append(rules, promcfg.RelabelConfig{
 			SourceLabels: []string{"__address__"},
-			Regex:        `(\d{1,3}\.\d{1,3}\.\d{1,3}.\d{1,3})(?::\d+)?`,
+			Regex:        addressHostRegex,
+			Replacement:  "$1$2$3",
 			Action:       "replace",
 			TargetLabel:  "__tmp_hash",
-		},
-		{
-			SourceLabels: []string{"__tmp_hash"},
-			Modulus:      c.TotalShardsCount,
-			Action:       "hashmod",
+		})
+	}
+
+	// The targets not discovered in a namespace, like the static ones, keep the hash of their address.
+	if c.Kind == KindNamespace {
+		rules = append(rules, promcfg.RelabelConfig{
+			SourceLabels: []string{namespaceLabel},
+			Regex:        "(.+)",
+			Action:       "replace",
+			TargetLabel:  "__tmp_hash",
+		})
+	}
+
+	hashSourceLabels := c.HashSourceLabels
+	if len(hashSourceLabels) == 0 {
+		hashSourceLabels = []string{"__tmp_hash"}
+	}
+
+	rules = append(rules, promcfg.RelabelConfig{
+		SourceLabels: hashSourceLabels,
+		Modulus:      c.TotalShardsCount,
+		Action:       "hashmod",
+		TargetLabel:  "__tmp_hash",
+	})
+
+	if c.Kind == KindNamespace {
+		rules = append(rules, c.pinRelabelConfigs()...)
+	}
+
+	return append(rules, promcfg.RelabelConfig{
+		SourceLabels: []string{"__tmp_hash"},
+		Regex:        fmt.Sprintf("^%v$", c.ShardIndex),
+		Action:       "keep",
+	})
+}
+
+// pinRelabelConfigs returns a rule by shard replacing the hash of its pinned namespaces by the shard index, sorted by
+// shard index.
+func (c Config) pinRelabelConfigs() []promcfg.RelabelConfig {
+	byShard := map[int][]string{}
+	for namespace, shard := range c.PinnedNamespaces {
+		byShard[shard] = append(byShard[shard], regexp.QuoteMeta(namespace))
+	}
+
+	rules := make([]promcfg.RelabelConfig, 0, len(byShard))
+
+	for _, shard := range slices.Sorted(maps.Keys(byShard)) {
+		namespaces := byShard[shard]
+		slices.Sort(namespaces)
+
+		rules = append(rules, promcfg.RelabelConfig{
+			SourceLabels: []string{namespaceLabel},
+			Regex:        strings.Join(namespaces, "|"),
+			Action:       "replace",
 			TargetLabel:  "__tmp_hash",
-		},
-		{
-			SourceLabels: []string{"__tmp_hash"},
-			Regex:        fmt.Sprintf("^%v$", c.ShardIndex),
-			Action:       "keep",
-		},
+			Replacement:  strconv.Itoa(shard),
+		})
 	}
+
+	return rules
 }
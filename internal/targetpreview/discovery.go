@@ -0,0 +1,540 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package targetpreview
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/util/strutil"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+)
+
+// The labels and roles below mirror the ones set by the Prometheus kubernetes service discovery.
+const (
+	metaLabelPrefix = "__meta_kubernetes_"
+	namespaceLabel  = metaLabelPrefix + "namespace"
+	addressLabel    = "__address__"
+
+	podRole       = "pod"
+	serviceRole   = "service"
+	endpointsRole = "endpoints"
+
+	presentValue = "true"
+)
+
+// DiscoveredTarget is a target as produced by the service discovery, before relabeling.
+type DiscoveredTarget struct {
+	// Source identifies the object the target was discovered from, like `pod/default/redis-0`.
+	Source string
+	Labels labels.Labels
+}
+
+// discoverer builds the targets of the supported roles from the objects, filtering them as the service discovery
+// would for the provided config.
+type discoverer struct {
+	objects  *Objects
+	sdConfig promcfg.KubernetesSdConfig
+}
+
+// Discover returns the targets Prometheus would discover with the provided config. Objects from namespaces not
+// included in the config or not matching its selectors are ignored. It returns an error for unsupported roles.
+func Discover(sdConfig promcfg.KubernetesSdConfig, objects *Objects) ([]DiscoveredTarget, error) {
+	d := discoverer{objects: objects, sdConfig: sdConfig}
+
+	switch sdConfig.Role {
+	case podRole:
+		return d.podTargets()
+	case endpointsRole:
+		return d.endpointsTargets()
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedRole, sdConfig.Role)
+	}
+}
+
+func (d discoverer) podTargets() ([]DiscoveredTarget, error) {
+	var targets []DiscoveredTarget
+
+	for _, pod := range d.objects.Pods {
+		selected, err := d.selected(podRole, pod.ObjectMeta, podFields(pod))
+		if err != nil {
+			return nil, err
+		}
+
+		// PodIP is empty when a pod is starting or has been evicted, the service discovery skips those.
+		if !selected || pod.Status.PodIP == "" {
+			continue
+		}
+
+		common := podLabels(pod)
+		common[namespaceLabel] = pod.Namespace
+		source := "pod/" + pod.Namespace + "/" + pod.Name
+
+		for _, c := range podContainers(pod) {
+			// Containers without ports get a single target with the pod IP as address.
+			if len(c.Ports) == 0 {
+				target := map[string]string{
+					addressLabel:                            pod.Status.PodIP,
+					metaLabelPrefix + "pod_container_name":  c.Name,
+					metaLabelPrefix + "pod_container_id":    c.id,
+					metaLabelPrefix + "pod_container_image": c.Image,
+					metaLabelPrefix + "pod_container_init":  strconv.FormatBool(c.init),
+				}
+				targets = append(targets, newTarget(source, common, target))
+
+				continue
+			}
+
+			for _, port := range c.Ports {
+				portNumber := strconv.Itoa(int(port.ContainerPort))
+				target := map[string]string{
+					addressLabel:                                    net.JoinHostPort(pod.Status.PodIP, portNumber),
+					metaLabelPrefix + "pod_container_name":          c.Name,
+					metaLabelPrefix + "pod_container_id":            c.id,
+					metaLabelPrefix + "pod_container_image":         c.Image,
+					metaLabelPrefix + "pod_container_port_number":   portNumber,
+					metaLabelPrefix + "pod_container_port_name":     port.Name,
+					metaLabelPrefix + "pod_container_port_protocol": string(port.Protocol),
+					metaLabelPrefix + "pod_container_init":          strconv.FormatBool(c.init),
+				}
+				targets = append(targets, newTarget(source, common, target))
+			}
+		}
+	}
+
+	return targets, nil
+}
+
+func (d discoverer) endpointsTargets() ([]DiscoveredTarget, error) {
+	var targets []DiscoveredTarget
+
+	for _, eps := range d.endpoints() {
+		selected, err := d.selected(endpointsRole, eps.ObjectMeta, objectFields(eps.ObjectMeta))
+		if err != nil {
+			return nil, err
+		}
+
+		if !selected {
+			continue
+		}
+
+		epsTargets, err := d.endpointsObjectTargets(eps)
+		if err != nil {
+			return nil, err
+		}
+
+		targets = append(targets, epsTargets...)
+	}
+
+	return targets, nil
+}
+
+func (d discoverer) endpointsObjectTargets(eps *corev1.Endpoints) ([]DiscoveredTarget, error) {
+	common := map[string]string{namespaceLabel: eps.Namespace}
+
+	service, err := d.service(eps.Namespace, eps.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if service != nil {
+		addObjectMetaLabels(common, service.ObjectMeta, serviceRole)
+	}
+
+	addObjectMetaLabels(common, eps.ObjectMeta, endpointsRole)
+
+	source := "endpoints/" + eps.Namespace + "/" + eps.Name
+
+	var targets []DiscoveredTarget
+
+	// Container ports of the pods backing the endpoints not exposed by the service get their own target.
+	seenPorts := map[*corev1.Pod][]int32{}
+
+	var seenPods []*corev1.Pod
+
+	add := func(addr corev1.EndpointAddress, port corev1.EndpointPort, ready string) error {
+		target := map[string]string{
+			addressLabel:                               net.JoinHostPort(addr.IP, strconv.Itoa(int(port.Port))),
+			metaLabelPrefix + "endpoint_port_name":     port.Name,
+			metaLabelPrefix + "endpoint_port_protocol": string(port.Protocol),
+			metaLabelPrefix + "endpoint_ready":         ready,
+			metaLabelPrefix + "endpoint_hostname":      addr.Hostname,
+		}
+
+		if addr.TargetRef != nil {
+			target[metaLabelPrefix+"endpoint_address_target_kind"] = addr.TargetRef.Kind
+			target[metaLabelPrefix+"endpoint_address_target_name"] = addr.TargetRef.Name
+		}
+
+		if addr.NodeName != nil {
+			target[metaLabelPrefix+"endpoint_node_name"] = *addr.NodeName
+		}
+
+		pod, err := d.resolvePod(addr.TargetRef)
+		if err != nil {
+			return err
+		}
+
+		// Targets not backed by a pod only get the endpoints labels.
+		if pod == nil {
+			targets = append(targets, newTarget(source, common, target))
+			return nil
+		}
+
+		if _, seen := seenPorts[pod]; !seen {
+			seenPods = append(seenPods, pod)
+		}
+
+		seenPorts[pod] = append(seenPorts[pod], port.Port)
+
+		maps.Copy(target, podLabels(pod))
+
+		for _, c := range podContainers(pod) {
+			if i := slices.IndexFunc(c.Ports, func(p corev1.ContainerPort) bool { return p.ContainerPort == port.Port }); i >= 0 {
+				maps.Copy(target, containerPortLabels(c, c.Ports[i]))
+				target[metaLabelPrefix+"pod_container_port_protocol"] = string(port.Protocol)
+
+				break
+			}
+		}
+
+		targets = append(targets, newTarget(source, common, target))
+
+		return nil
+	}
+
+	for _, subset := range eps.Subsets {
+		for _, port := range subset.Ports {
+			for _, addr := range subset.Addresses {
+				if err := add(addr, port, "true"); err != nil {
+					return nil, err
+				}
+			}
+
+			for _, addr := range subset.NotReadyAddresses {
+				if err := add(addr, port, "false"); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	for _, pod := range seenPods {
+		if pod.Status.PodIP == "" {
+			continue
+		}
+
+		for _, c := range podContainers(pod) {
+			for _, port := range c.Ports {
+				if slices.Contains(seenPorts[pod], port.ContainerPort) {
+					continue
+				}
+
+				target := containerPortLabels(c, port)
+				target[addressLabel] = net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(port.ContainerPort)))
+				maps.Copy(target, podLabels(pod))
+				targets = append(targets, newTarget(source, common, target))
+			}
+		}
+	}
+
+	return targets, nil
+}
+
+// endpoints returns the Endpoints objects, including the ones derived from EndpointSlices of services having no
+// Endpoints object in the manifests.
+func (d discoverer) endpoints() []*corev1.Endpoints {
+	endpoints := slices.Clone(d.objects.Endpoints)
+
+	existing := map[string]bool{}
+	for _, eps := range endpoints {
+		existing[eps.Namespace+"/"+eps.Name] = true
+	}
+
+	derived := map[string]*corev1.Endpoints{}
+
+	for _, slice := range d.objects.EndpointSlices {
+		serviceName := slice.Labels[discoveryv1.LabelServiceName]
+		key := slice.Namespace + "/" + serviceName
+
+		if serviceName == "" || existing[key] {
+			continue
+		}
+
+		eps, ok := derived[key]
+		if !ok {
+			eps = &corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{
+				Name:        serviceName,
+				Namespace:   slice.Namespace,
+				Labels:      endpointsLabelsFromSlice(slice.Labels),
+				Annotations: slice.Annotations,
+			}}
+			derived[key] = eps
+			endpoints = append(endpoints, eps)
+		}
+
+		eps.Subsets = append(eps.Subsets, subsetFromSlice(slice))
+	}
+
+	return endpoints
+}
+
+// endpointsLabelsFromSlice removes the labels set by the EndpointSlice controller, the remaining ones are the service
+// labels which are the ones the Endpoints object has.
+func endpointsLabelsFromSlice(sliceLabels map[string]string) map[string]string {
+	epsLabels := maps.Clone(sliceLabels)
+	delete(epsLabels, discoveryv1.LabelServiceName)
+	delete(epsLabels, discoveryv1.LabelManagedBy)
+
+	return epsLabels
+}
+
+func subsetFromSlice(slice *discoveryv1.EndpointSlice) corev1.EndpointSubset {
+	subset := corev1.EndpointSubset{}
+
+	for _, port := range slice.Ports {
+		epPort := corev1.EndpointPort{}
+		if port.Name != nil {
+			epPort.Name = *port.Name
+		}
+
+		if port.Port != nil {
+			epPort.Port = *port.Port
+		}
+
+		if port.Protocol != nil {
+			epPort.Protocol = *port.Protocol
+		}
+
+		subset.Ports = append(subset.Ports, epPort)
+	}
+
+	for _, endpoint := range slice.Endpoints {
+		for _, ip := range endpoint.Addresses {
+			addr := corev1.EndpointAddress{IP: ip, TargetRef: endpoint.TargetRef, NodeName: endpoint.NodeName}
+			if endpoint.Hostname != nil {
+				addr.Hostname = *endpoint.Hostname
+			}
+
+			// A nil ready condition must be interpreted as ready.
+			if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+				subset.Addresses = append(subset.Addresses, addr)
+			} else {
+				subset.NotReadyAddresses = append(subset.NotReadyAddresses, addr)
+			}
+		}
+	}
+
+	return subset
+}
+
+func (d discoverer) service(namespace string, name string) (*corev1.Service, error) {
+	for _, service := range d.objects.Services {
+		if service.Namespace != namespace || service.Name != name {
+			continue
+		}
+
+		selected, err := d.selected(serviceRole, service.ObjectMeta, objectFields(service.ObjectMeta))
+		if err != nil || !selected {
+			return nil, err
+		}
+
+		return service, nil
+	}
+
+	return nil, nil //nolint: nilnil
+}
+
+func (d discoverer) resolvePod(ref *corev1.ObjectReference) (*corev1.Pod, error) {
+	if ref == nil || ref.Kind != "Pod" {
+		return nil, nil //nolint: nilnil
+	}
+
+	for _, pod := range d.objects.Pods {
+		if pod.Namespace != ref.Namespace || pod.Name != ref.Name {
+			continue
+		}
+
+		selected, err := d.selected(podRole, pod.ObjectMeta, podFields(pod))
+		if err != nil || !selected {
+			return nil, err
+		}
+
+		return pod, nil
+	}
+
+	return nil, nil //nolint: nilnil
+}
+
+// selected returns true if the object is in one of the namespaces of the config and matches the selectors for its
+// role. The own_namespace option is ignored since the namespace of the Prometheus instance is unknown.
+func (d discoverer) selected(role string, meta metav1.ObjectMeta, objectFields fields.Set) (bool, error) {
+	if ns := d.sdConfig.Namespaces; ns != nil && len(ns.Names) > 0 && !slices.Contains(ns.Names, meta.Namespace) {
+		return false, nil
+	}
+
+	if d.sdConfig.Selectors == nil {
+		return true, nil
+	}
+
+	for _, selector := range *d.sdConfig.Selectors {
+		if selector.Role != role {
+			continue
+		}
+
+		if selector.Label != "" {
+			labelSelector, err := k8slabels.Parse(selector.Label)
+			if err != nil {
+				return false, fmt.Errorf("parsing label selector %q: %w", selector.Label, err)
+			}
+
+			if !labelSelector.Matches(k8slabels.Set(meta.Labels)) {
+				return false, nil
+			}
+		}
+
+		if selector.Field != "" {
+			fieldSelector, err := fields.ParseSelector(selector.Field)
+			if err != nil {
+				return false, fmt.Errorf("parsing field selector %q: %w", selector.Field, err)
+			}
+
+			if !fieldSelector.Matches(objectFields) {
+				return false, nil
+			}
+		}
+	}
+
+	return true, nil
+}
+
+func objectFields(meta metav1.ObjectMeta) fields.Set {
+	return fields.Set{"metadata.name": meta.Name, "metadata.namespace": meta.Namespace}
+}
+
+// podFields returns the fields supported by the API server in pod field selectors.
+func podFields(pod *corev1.Pod) fields.Set {
+	podFields := objectFields(pod.ObjectMeta)
+	podFields["spec.nodeName"] = pod.Spec.NodeName
+	podFields["spec.restartPolicy"] = string(pod.Spec.RestartPolicy)
+	podFields["spec.schedulerName"] = pod.Spec.SchedulerName
+	podFields["spec.serviceAccountName"] = pod.Spec.ServiceAccountName
+	podFields["status.phase"] = string(pod.Status.Phase)
+	podFields["status.podIP"] = pod.Status.PodIP
+	podFields["status.nominatedNodeName"] = pod.Status.NominatedNodeName
+
+	return podFields
+}
+
+func podLabels(pod *corev1.Pod) map[string]string {
+	podLabels := map[string]string{
+		metaLabelPrefix + "pod_ip":        pod.Status.PodIP,
+		metaLabelPrefix + "pod_ready":     podReady(pod),
+		metaLabelPrefix + "pod_phase":     string(pod.Status.Phase),
+		metaLabelPrefix + "pod_node_name": pod.Spec.NodeName,
+		metaLabelPrefix + "pod_host_ip":   pod.Status.HostIP,
+		metaLabelPrefix + "pod_uid":       string(pod.UID),
+	}
+
+	addObjectMetaLabels(podLabels, pod.ObjectMeta, podRole)
+
+	if controller := metav1.GetControllerOf(pod); controller != nil {
+		podLabels[metaLabelPrefix+"pod_controller_kind"] = controller.Kind
+		podLabels[metaLabelPrefix+"pod_controller_name"] = controller.Name
+	}
+
+	return podLabels
+}
+
+func podReady(pod *corev1.Pod) string {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return strings.ToLower(string(cond.Status))
+		}
+	}
+
+	return strings.ToLower(string(corev1.ConditionUnknown))
+}
+
+type container struct {
+	corev1.Container
+	id   string
+	init bool
+}
+
+// podContainers returns the containers followed by the init containers, as the service discovery does.
+func podContainers(pod *corev1.Pod) []container {
+	containers := make([]container, 0, len(pod.Spec.Containers)+len(pod.Spec.InitContainers))
+
+	for _, c := range pod.Spec.Containers {
+		containers = append(containers, container{Container: c, id: containerID(pod.Status.ContainerStatuses, c.Name)})
+	}
+
+	for _, c := range pod.Spec.InitContainers {
+		containers = append(containers, container{Container: c, id: containerID(pod.Status.InitContainerStatuses, c.Name), init: true})
+	}
+
+	return containers
+}
+
+func containerID(statuses []corev1.ContainerStatus, name string) string {
+	for _, status := range statuses {
+		if status.Name == name {
+			return status.ContainerID
+		}
+	}
+
+	return ""
+}
+
+func containerPortLabels(c container, port corev1.ContainerPort) map[string]string {
+	return map[string]string{
+		metaLabelPrefix + "pod_container_name":          c.Name,
+		metaLabelPrefix + "pod_container_image":         c.Image,
+		metaLabelPrefix + "pod_container_port_name":     port.Name,
+		metaLabelPrefix + "pod_container_port_number":   strconv.Itoa(int(port.ContainerPort)),
+		metaLabelPrefix + "pod_container_port_protocol": string(port.Protocol),
+		metaLabelPrefix + "pod_container_init":          strconv.FormatBool(c.init),
+	}
+}
+
+func addObjectMetaLabels(labelSet map[string]string, meta metav1.ObjectMeta, role string) {
+	labelSet[metaLabelPrefix+role+"_name"] = meta.Name
+
+	for k, v := range meta.Labels {
+		name := strutil.SanitizeLabelName(k)
+		labelSet[metaLabelPrefix+role+"_label_"+name] = v
+		labelSet[metaLabelPrefix+role+"_labelpresent_"+name] = presentValue
+	}
+
+	for k, v := range meta.Annotations {
+		name := strutil.SanitizeLabelName(k)
+		labelSet[metaLabelPrefix+role+"_annotation_"+name] = v
+		labelSet[metaLabelPrefix+role+"_annotationpresent_"+name] = presentValue
+	}
+}
+
+// newTarget merges the labels of the target group with the ones of the target, which take precedence. Empty values
+// are dropped since Prometheus handles them as missing labels.
+func newTarget(source string, group map[string]string, target map[string]string) DiscoveredTarget {
+	lb := labels.NewBuilder(labels.EmptyLabels())
+
+	for _, labelSet := range []map[string]string{group, target} {
+		for name, value := range labelSet {
+			lb.Set(name, value)
+		}
+	}
+
+	return DiscoveredTarget{Source: source, Labels: lb.Labels()}
+}
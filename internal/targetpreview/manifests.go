@@ -0,0 +1,153 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package targetpreview
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// manifestExtensions are the file extensions loaded when reading a directory.
+//
+//nolint:gochecknoglobals
+var manifestExtensions = map[string]bool{".yaml": true, ".yml": true, ".json": true}
+
+// Objects holds the kubernetes objects used by the service discovery of the supported roles.
+type Objects struct {
+	Pods           []*corev1.Pod
+	Services       []*corev1.Service
+	Endpoints      []*corev1.Endpoints
+	EndpointSlices []*discoveryv1.EndpointSlice
+	// Skipped counts the objects of kinds not involved in the discovery.
+	Skipped int
+}
+
+// LoadManifests reads the objects from the provided files and directories. Files can hold several yaml documents and
+// `List` objects, like the ones printed by `kubectl get -o yaml`. Directories are read recursively.
+func LoadManifests(paths ...string) (*Objects, error) {
+	objects := &Objects{}
+	decoder := newDecoder()
+
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(file string, entry os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// Files explicitly provided are read regardless of their extension.
+			if entry.IsDir() || (file != path && !manifestExtensions[filepath.Ext(file)]) {
+				return nil
+			}
+
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("reading manifest: %w", err)
+			}
+
+			if err := objects.add(decoder, data); err != nil {
+				return fmt.Errorf("loading %s: %w", file, err)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("loading manifests: %w", err)
+		}
+	}
+
+	return objects, nil
+}
+
+func newDecoder() runtime.Decoder {
+	scheme := runtime.NewScheme()
+	// The registration only fails for conflicting types, which cannot happen on a new scheme.
+	_ = corev1.AddToScheme(scheme)
+	_ = discoveryv1.AddToScheme(scheme)
+
+	return serializer.NewCodecFactory(scheme).UniversalDeserializer()
+}
+
+func (o *Objects) add(decoder runtime.Decoder, data []byte) error {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+
+	for {
+		document, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("reading yaml document: %w", err)
+		}
+
+		if strings.TrimSpace(string(document)) == "" {
+			continue
+		}
+
+		if err := o.addDocument(decoder, document); err != nil {
+			return err
+		}
+	}
+}
+
+func (o *Objects) addDocument(decoder runtime.Decoder, document []byte) error {
+	obj, _, err := decoder.Decode(document, nil, nil)
+	if runtime.IsNotRegisteredError(err) {
+		o.Skipped++
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("decoding object: %w", err)
+	}
+
+	switch typed := obj.(type) {
+	case *corev1.Pod:
+		o.Pods = append(o.Pods, typed)
+	case *corev1.Service:
+		o.Services = append(o.Services, typed)
+	case *corev1.Endpoints:
+		o.Endpoints = append(o.Endpoints, typed)
+	case *discoveryv1.EndpointSlice:
+		o.EndpointSlices = append(o.EndpointSlices, typed)
+	case *corev1.PodList:
+		for i := range typed.Items {
+			o.Pods = append(o.Pods, &typed.Items[i])
+		}
+	case *corev1.ServiceList:
+		for i := range typed.Items {
+			o.Services = append(o.Services, &typed.Items[i])
+		}
+	case *corev1.EndpointsList:
+		for i := range typed.Items {
+			o.Endpoints = append(o.Endpoints, &typed.Items[i])
+		}
+	case *discoveryv1.EndpointSliceList:
+		for i := range typed.Items {
+			o.EndpointSlices = append(o.EndpointSlices, &typed.Items[i])
+		}
+	case *corev1.List:
+		for _, item := range typed.Items {
+			if err := o.addDocument(decoder, item.Raw); err != nil {
+				return err
+			}
+		}
+	default:
+		o.Skipped++
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package targetpreview simulates offline the kubernetes service discovery and relabeling of a generated Prometheus
+// config over a snapshot of kubernetes manifests, so the targets each shard would scrape can be reviewed without a
+// live cluster.
+package targetpreview
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/relabeling"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/sharding"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v3"
+)
+
+// AllShards is the shard of the targets from jobs skipping sharding, which are scraped by every shard.
+const AllShards = -1
+
+var ErrUnsupportedRole = errors.New("unsupported kubernetes_sd_configs role")
+
+// Target is a discovered target once relabeled.
+type Target struct {
+	Job string
+	// Source identifies the object the target was discovered from, like `pod/default/redis-0`.
+	Source string
+	// Shard is the index of the shard scraping the target, or AllShards.
+	Shard int
+	// URL is the scrape URL of the target, empty if dropped.
+	URL string
+	// Labels are the labels attached to the scraped series, empty if dropped.
+	Labels labels.Labels
+	// DroppedBy is the 1-based position in the job relabel_configs of the rule dropping the target, or 0 if kept.
+	DroppedBy int
+}
+
+// SkippedJob is a job with kubernetes service discovery which could not be previewed.
+type SkippedJob struct {
+	Job    string
+	Reason string
+}
+
+// Report holds the result of the preview.
+type Report struct {
+	// Targets holds every discovered target, sorted by shard, job and URL.
+	Targets []Target
+	Skipped []SkippedJob
+}
+
+// Kept returns the targets which are scraped.
+func (r *Report) Kept() []Target {
+	return slices.DeleteFunc(slices.Clone(r.Targets), func(t Target) bool { return t.DroppedBy != 0 })
+}
+
+// Dropped returns the targets dropped by relabeling.
+func (r *Report) Dropped() []Target {
+	return slices.DeleteFunc(slices.Clone(r.Targets), func(t Target) bool { return t.DroppedBy == 0 })
+}
+
+// sdJob holds the service discovery config of a scrape job.
+type sdJob struct {
+	JobName             string                       `yaml:"job_name"`
+	KubernetesSdConfigs []promcfg.KubernetesSdConfig `yaml:"kubernetes_sd_configs"`
+}
+
+// Preview runs every job of the Prometheus config using kubernetes service discovery over the objects. The sharding
+// config must be the one used to build the Prometheus config, so the sharding rules can be recognized.
+func Preview(prometheusConfig []byte, shardingConfig sharding.Config, objects *Objects) (*Report, error) {
+	relabelConfig, err := relabeling.Load(prometheusConfig)
+	if err != nil {
+		return nil, fmt.Errorf("loading relabel configs: %w", err)
+	}
+
+	sdConfig := struct {
+		ScrapeConfigs []sdJob `yaml:"scrape_configs"`
+	}{}
+
+	if err := yaml.Unmarshal(prometheusConfig, &sdConfig); err != nil {
+		return nil, fmt.Errorf("parsing prometheus config: %w", err)
+	}
+
+	report := &Report{}
+
+	for _, job := range sdConfig.ScrapeConfigs {
+		if len(job.KubernetesSdConfigs) == 0 {
+			continue
+		}
+
+		scrapeConfig, err := relabelConfig.ScrapeConfig(job.JobName)
+		if err != nil {
+			return nil, fmt.Errorf("loading relabel configs: %w", err)
+		}
+
+		targets, err := previewJob(scrapeConfig, job.KubernetesSdConfigs, shardingConfig, objects)
+		if errors.Is(err, ErrUnsupportedRole) {
+			report.Skipped = append(report.Skipped, SkippedJob{Job: job.JobName, Reason: err.Error()})
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("previewing job %q: %w", job.JobName, err)
+		}
+
+		report.Targets = append(report.Targets, targets...)
+	}
+
+	slices.SortStableFunc(report.Targets, func(a, b Target) int {
+		return cmp.Or(cmp.Compare(a.Shard, b.Shard), cmp.Compare(a.Job, b.Job), cmp.Compare(a.URL, b.URL))
+	})
+
+	return report, nil
+}
+
+func previewJob(
+	scrapeConfig *relabeling.ScrapeConfig,
+	sdConfigs []promcfg.KubernetesSdConfig,
+	shardingConfig sharding.Config,
+	objects *Objects,
+) ([]Target, error) {
+	rules, sharded, err := relabeling.SplitShardingRules(scrapeConfig.RelabelConfigs, shardingConfig)
+	if err != nil {
+		return nil, fmt.Errorf("splitting sharding rules: %w", err)
+	}
+
+	var targets []Target
+
+	// Prometheus scrapes only once targets ending up with the same labels, like several container ports of a pod
+	// relabeled to the port in the annotations.
+	seen := map[string]bool{}
+
+	for _, sdConfig := range sdConfigs {
+		discovered, err := Discover(sdConfig, objects)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range discovered {
+			target, err := relabelTarget(scrapeConfig, d, rules, sharded, shardingConfig)
+			if err != nil {
+				return nil, err
+			}
+
+			if target.DroppedBy == 0 {
+				key := target.URL + target.Labels.String()
+				if seen[key] {
+					continue
+				}
+
+				seen[key] = true
+			}
+
+			targets = append(targets, target)
+		}
+	}
+
+	return targets, nil
+}
+
+// relabelTarget runs the job rules over the target, for sharded jobs with the sharding rules of the shard owning it.
+func relabelTarget(
+	scrapeConfig *relabeling.ScrapeConfig,
+	discovered DiscoveredTarget,
+	rules []*relabel.Config,
+	sharded bool,
+	shardingConfig sharding.Config,
+) (Target, error) {
+	input := scrapeConfig.TargetLabels(discovered.Labels)
+	target := Target{Job: scrapeConfig.JobName, Source: discovered.Source, Shard: AllShards}
+
+	if sharded {
+		owner, err := relabeling.ShardOwner(shardingConfig, input)
+		if err != nil {
+			return Target{}, fmt.Errorf("computing the shard owner: %w", err)
+		}
+
+		shardingRules, err := relabeling.ShardingRules(shardingConfig, owner)
+		if err != nil {
+			return Target{}, err //nolint: wrapcheck
+		}
+
+		target.Shard = owner
+		rules = append(shardingRules, rules...)
+	}
+
+	result := relabeling.Process(rules, input)
+	if !result.Kept {
+		target.DroppedBy = result.DroppedBy()
+		return target, nil
+	}
+
+	target.URL = relabeling.ScrapeURL(result.Labels)
+	target.Labels = relabeling.ScrapedLabels(result.Labels)
+
+	return target, nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package targetpreview_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/configurator"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/promcfg"
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/targetpreview"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestLoadManifests(t *testing.T) {
+	t.Parallel()
+
+	objects, err := targetpreview.LoadManifests("testdata/manifests")
+	require.NoError(t, err)
+
+	assert.Len(t, objects.Pods, 3)
+	assert.Len(t, objects.Services, 1)
+	assert.Len(t, objects.EndpointSlices, 1)
+	assert.Empty(t, objects.Endpoints)
+	assert.Equal(t, 1, objects.Skipped)
+}
+
+func TestDiscoverPods(t *testing.T) {
+	t.Parallel()
+
+	objects, err := targetpreview.LoadManifests("testdata/manifests/pods.yaml")
+	require.NoError(t, err)
+
+	targets, err := targetpreview.Discover(promcfg.KubernetesSdConfig{Role: "pod"}, objects)
+	require.NoError(t, err)
+
+	// One target per container port, the pending pod has no IP yet.
+	require.Len(t, targets, 3)
+
+	redis := targets[1].Labels
+	assert.Equal(t, "pod/default/redis-0", targets[1].Source)
+	assert.Equal(t, "10.0.0.10:9121", redis.Get("__address__"))
+	assert.Equal(t, "exporter", redis.Get("__meta_kubernetes_pod_container_name"))
+	assert.Equal(t, "metrics", redis.Get("__meta_kubernetes_pod_container_port_name"))
+	assert.Equal(t, "9121", redis.Get("__meta_kubernetes_pod_container_port_number"))
+	assert.Equal(t, "true", redis.Get("__meta_kubernetes_pod_annotation_prometheus_io_scrape"))
+	assert.Equal(t, "true", redis.Get("__meta_kubernetes_pod_annotationpresent_prometheus_io_scrape"))
+	assert.Equal(t, "redis", redis.Get("__meta_kubernetes_pod_label_app_kubernetes_io_name"))
+	assert.Equal(t, "true", redis.Get("__meta_kubernetes_pod_ready"))
+	assert.Equal(t, "Running", redis.Get("__meta_kubernetes_pod_phase"))
+	assert.Equal(t, "node-a", redis.Get("__meta_kubernetes_pod_node_name"))
+	assert.Equal(t, "default", redis.Get("__meta_kubernetes_namespace"))
+	// Empty values are not set.
+	assert.False(t, redis.Has("__meta_kubernetes_pod_container_id"))
+}
+
+func TestDiscoverFilters(t *testing.T) {
+	t.Parallel()
+
+	objects, err := targetpreview.LoadManifests("testdata/manifests")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		sdConfig promcfg.KubernetesSdConfig
+		expected []string
+	}{
+		{
+			name:     "Namespaces",
+			sdConfig: promcfg.KubernetesSdConfig{Role: "pod", Namespaces: &promcfg.KubernetesSdNamespace{Names: []string{"shop"}}},
+			expected: []string{"pod/shop/api-7d9f"},
+		},
+		{
+			name: "FieldSelector",
+			sdConfig: promcfg.KubernetesSdConfig{Role: "pod", Selectors: &[]promcfg.KubernetesSdSelector{
+				{Role: "pod", Field: "spec.nodeName=node-a"},
+			}},
+			expected: []string{"pod/default/redis-0", "pod/default/redis-0"},
+		},
+		{
+			name: "LabelSelector",
+			sdConfig: promcfg.KubernetesSdConfig{Role: "pod", Selectors: &[]promcfg.KubernetesSdSelector{
+				{Role: "pod", Label: "app=api"},
+			}},
+			expected: []string{"pod/shop/api-7d9f"},
+		},
+		{
+			name:     "EndpointsFromEndpointSlices",
+			sdConfig: promcfg.KubernetesSdConfig{Role: "endpoints"},
+			expected: []string{"endpoints/shop/api"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			targets, err := targetpreview.Discover(tc.sdConfig, objects)
+			require.NoError(t, err)
+
+			sources := make([]string, 0, len(targets))
+			for _, target := range targets {
+				sources = append(sources, target.Source)
+			}
+
+			assert.Equal(t, tc.expected, sources)
+		})
+	}
+
+	_, err = targetpreview.Discover(promcfg.KubernetesSdConfig{Role: "ingress"}, objects)
+	require.ErrorIs(t, err, targetpreview.ErrUnsupportedRole)
+}
+
+func TestDiscoverEndpointsLabels(t *testing.T) {
+	t.Parallel()
+
+	objects, err := targetpreview.LoadManifests("testdata/manifests")
+	require.NoError(t, err)
+
+	targets, err := targetpreview.Discover(promcfg.KubernetesSdConfig{Role: "endpoints"}, objects)
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+
+	lbls := targets[0].Labels
+	assert.Equal(t, "10.0.0.20:8080", lbls.Get("__address__"))
+	assert.Equal(t, "api", lbls.Get("__meta_kubernetes_endpoints_name"))
+	assert.Equal(t, "api", lbls.Get("__meta_kubernetes_service_name"))
+	assert.Equal(t, "true", lbls.Get("__meta_kubernetes_service_annotation_prometheus_io_scrape"))
+	assert.Equal(t, "true", lbls.Get("__meta_kubernetes_endpoint_ready"))
+	assert.Equal(t, "node-b", lbls.Get("__meta_kubernetes_endpoint_node_name"))
+	assert.Equal(t, "api-7d9f", lbls.Get("__meta_kubernetes_pod_name"))
+	assert.Equal(t, "api", lbls.Get("__meta_kubernetes_pod_container_name"))
+	// Labels set by the EndpointSlice controller are not part of the Endpoints object.
+	assert.False(t, lbls.Has("__meta_kubernetes_endpoints_label_kubernetes_io_service_name"))
+}
+
+func TestPreview(t *testing.T) {
+	t.Setenv(configurator.DataSourceNameEnvKey, "")
+
+	data, err := os.ReadFile("testdata/nr-config.yaml")
+	require.NoError(t, err)
+
+	nrConfig, _, err := configurator.DecodeNrConfig(data, false)
+	require.NoError(t, err)
+
+	nrConfig.RemoteWrite.LicenseKey = "fake"
+	nrConfig.Sharding.ShardIndex = "1"
+
+	prometheusConfig, err := configurator.BuildPromConfig(nrConfig)
+	require.NoError(t, err)
+
+	prometheusConfigData, err := yaml.Marshal(prometheusConfig)
+	require.NoError(t, err)
+
+	objects, err := targetpreview.LoadManifests("testdata/manifests")
+	require.NoError(t, err)
+
+	report, err := targetpreview.Preview(prometheusConfigData, nrConfig.Sharding, objects)
+	require.NoError(t, err)
+
+	type previewed struct {
+		Job       string
+		Shard     int
+		URL       string
+		DroppedBy int
+	}
+
+	actual := make([]previewed, 0, len(report.Targets))
+	for _, target := range report.Targets {
+		actual = append(actual, previewed{Job: target.Job, Shard: target.Shard, URL: target.URL, DroppedBy: target.DroppedBy})
+	}
+
+	// Targets are assigned to their shard even if the config was built for another one, the redis pod exposes two
+	// ports but both are relabeled to the one in the annotations, so it is scraped once.
+	expected := []previewed{
+		{Job: "default-endpoints", Shard: 0, URL: "http://10.0.0.20:8080/stats"},
+		{Job: "default-pod", Shard: 0, DroppedBy: 4},
+		{Job: "default-pod", Shard: 0, URL: "http://10.0.0.10:9121/metrics"},
+	}
+	assert.Equal(t, expected, actual)
+
+	assert.Equal(t, "redis-0", report.Kept()[1].Labels.Get("pod"))
+	assert.Len(t, report.Dropped(), 1)
+	assert.Empty(t, report.Skipped)
+}
@@ -0,0 +1,182 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package watcher keeps the generated prometheus configuration up to date while the configurator is running and
+// triggers a Prometheus reload whenever it changes.
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/atomicfile"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultInterval is the time between two consecutive checks of the configuration sources.
+	DefaultInterval = 10 * time.Second
+	// DefaultMaxBackoff is the maximum time between two checks when the previous ones failed.
+	DefaultMaxBackoff = 5 * time.Minute
+	// DefaultReloadURL is the Prometheus endpoint which triggers a configuration reload. Prometheus needs to be
+	// started with `--web.enable-lifecycle` for it to be available.
+	DefaultReloadURL = "http://localhost:9090/-/reload"
+
+	reloadTimeout = 30 * time.Second
+	// maxReloadErrorBody limits the amount of the response body included in reload errors.
+	maxReloadErrorBody = 512
+)
+
+var ErrReloadFailed = errors.New("prometheus reload failed")
+
+// File represents a file the Watcher keeps up to date on disk.
+type File struct {
+	Path string
+	Data []byte
+	Perm os.FileMode
+}
+
+// RenderFunc builds the files which should be written. It is called on every check, so it is expected to read again
+// all the configuration sources (input files, environment variables, ...).
+type RenderFunc func() ([]File, error)
+
+// Config holds the Watcher settings.
+type Config struct {
+	// Interval is the time between two consecutive checks.
+	Interval time.Duration
+	// MaxBackoff limits the time between checks when they keep failing.
+	MaxBackoff time.Duration
+	// ReloadURL is the endpoint called after a file changes. The reload is skipped if it is empty.
+	ReloadURL string
+}
+
+// Watcher periodically renders the configuration, writes it atomically when it changes and asks Prometheus to
+// reload it. When rendering fails the files on disk are left untouched, so Prometheus keeps the last good config.
+type Watcher struct {
+	config Config
+	render RenderFunc
+	client *http.Client
+	logger *log.Logger
+
+	// pendingReload is set when files have changed but Prometheus could not be reloaded yet.
+	pendingReload bool
+}
+
+// New returns a Watcher, zero values in the config are replaced by the defaults.
+func New(config Config, render RenderFunc, logger *log.Logger) *Watcher {
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+
+	if config.MaxBackoff < config.Interval {
+		config.MaxBackoff = max(DefaultMaxBackoff, config.Interval)
+	}
+
+	return &Watcher{
+		config: config,
+		render: render,
+		client: &http.Client{Timeout: reloadTimeout},
+		logger: logger,
+	}
+}
+
+// Run checks the configuration until the context is cancelled. Failed checks are retried with an exponential backoff.
+func (w *Watcher) Run(ctx context.Context) {
+	delay := w.config.Interval
+
+	for {
+		if err := w.Sync(ctx); err != nil {
+			delay = min(delay*2, w.config.MaxBackoff)
+			w.logger.Errorf("Error updating the prometheus configuration, retrying in %s: %s", delay, err)
+		} else {
+			delay = w.config.Interval
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// Sync renders the configuration once, writes the files whose content changed and reloads Prometheus if needed.
+func (w *Watcher) Sync(ctx context.Context) error {
+	files, err := w.render()
+	if err != nil {
+		return fmt.Errorf("rendering configuration, keeping the last good one: %w", err)
+	}
+
+	for _, file := range files {
+		changed, err := writeIfChanged(file)
+		if err != nil {
+			return err
+		}
+
+		if changed {
+			w.logger.Infof("File %s has been updated", file.Path)
+			w.pendingReload = true
+		}
+	}
+
+	if !w.pendingReload || w.config.ReloadURL == "" {
+		w.pendingReload = false
+		return nil
+	}
+
+	if err := w.reload(ctx); err != nil {
+		return err
+	}
+
+	w.logger.Info("Prometheus configuration reloaded")
+	w.pendingReload = false
+
+	return nil
+}
+
+func (w *Watcher) reload(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.ReloadURL, nil)
+	if err != nil {
+		return fmt.Errorf("creating reload request: %w", err)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrReloadFailed, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxReloadErrorBody))
+		return fmt.Errorf("%w: status %d: %s", ErrReloadFailed, resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	return nil
+}
+
+// writeIfChanged writes the file only when its content on disk is different, it returns true if it was written.
+func writeIfChanged(file File) (bool, error) {
+	current, err := os.ReadFile(file.Path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("reading %s: %w", file.Path, err)
+	}
+
+	if err == nil && bytes.Equal(current, file.Data) {
+		return false, nil
+	}
+
+	if err := atomicfile.Write(file.Path, file.Data, file.Perm); err != nil {
+		return false, fmt.Errorf("writing %s: %w", file.Path, err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/watcher"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+var errRender = errors.New("invalid config")
+
+type reloadServer struct {
+	*httptest.Server
+	calls  atomic.Int32
+	status atomic.Int32
+}
+
+func newReloadServer(t *testing.T) *reloadServer {
+	t.Helper()
+
+	rs := &reloadServer{}
+	rs.status.Store(http.StatusOK)
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/-/reload" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rs.calls.Add(1)
+		w.WriteHeader(int(rs.status.Load()))
+	}))
+	t.Cleanup(rs.Close)
+
+	return rs
+}
+
+func TestSync(t *testing.T) {
+	t.Parallel()
+
+	server := newReloadServer(t)
+	output := filepath.Join(t.TempDir(), "config.yaml")
+
+	content := []byte("first")
+	var renderErr error
+	render := func() ([]watcher.File, error) {
+		if renderErr != nil {
+			return nil, renderErr
+		}
+		return []watcher.File{{Path: output, Data: content, Perm: 0o644}}, nil
+	}
+
+	w := watcher.New(watcher.Config{ReloadURL: server.URL + "/-/reload"}, render, log.StandardLogger())
+	ctx := context.Background()
+
+	// The file is written and Prometheus reloaded the first time.
+	require.NoError(t, w.Sync(ctx))
+	requireFileContent(t, output, "first")
+	require.EqualValues(t, 1, server.calls.Load())
+
+	// Nothing changed, so no reload is expected.
+	require.NoError(t, w.Sync(ctx))
+	require.EqualValues(t, 1, server.calls.Load())
+
+	// A failing render keeps the last good config.
+	renderErr = errRender
+	require.ErrorIs(t, w.Sync(ctx), errRender)
+	requireFileContent(t, output, "first")
+	require.EqualValues(t, 1, server.calls.Load())
+
+	// Once fixed, the new content is written and reloaded.
+	renderErr = nil
+	content = []byte("second")
+	require.NoError(t, w.Sync(ctx))
+	requireFileContent(t, output, "second")
+	require.EqualValues(t, 2, server.calls.Load())
+}
+
+func TestSyncRetriesFailedReloads(t *testing.T) {
+	t.Parallel()
+
+	server := newReloadServer(t)
+	server.status.Store(http.StatusServiceUnavailable)
+	output := filepath.Join(t.TempDir(), "config.yaml")
+
+	render := func() ([]watcher.File, error) {
+		return []watcher.File{{Path: output, Data: []byte("content"), Perm: 0o644}}, nil
+	}
+
+	w := watcher.New(watcher.Config{ReloadURL: server.URL + "/-/reload"}, render, log.StandardLogger())
+	ctx := context.Background()
+
+	require.ErrorIs(t, w.Sync(ctx), watcher.ErrReloadFailed)
+	requireFileContent(t, output, "content")
+
+	// The file did not change but the reload is still pending.
+	server.status.Store(http.StatusOK)
+	require.NoError(t, w.Sync(ctx))
+	require.EqualValues(t, 2, server.calls.Load())
+
+	require.NoError(t, w.Sync(ctx))
+	require.EqualValues(t, 2, server.calls.Load())
+}
+
+func TestSyncSkipsReloadWhenContentIsAlreadyOnDisk(t *testing.T) {
+	t.Parallel()
+
+	server := newReloadServer(t)
+	output := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(output, []byte("content"), 0o600))
+
+	render := func() ([]watcher.File, error) {
+		return []watcher.File{{Path: output, Data: []byte("content"), Perm: 0o644}}, nil
+	}
+
+	w := watcher.New(watcher.Config{ReloadURL: server.URL + "/-/reload"}, render, log.StandardLogger())
+	require.NoError(t, w.Sync(context.Background()))
+	require.EqualValues(t, 0, server.calls.Load())
+}
+
+func requireFileContent(t *testing.T, path string, expected string) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, expected, string(data))
+}
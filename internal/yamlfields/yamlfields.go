@@ -0,0 +1,71 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package yamlfields lists the keys the yaml library decodes into the fields of a struct, so the unknown fields check
+// and the published schema agree on them.
+package yamlfields
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Field is a struct field along with its yaml key.
+type Field struct {
+	Name string
+	reflect.StructField
+}
+
+// Of returns the exported fields of the struct type decoded by the yaml library. The fields of inlined structs are
+// returned in place of the field including them, since that is where the yaml library expects their keys.
+func Of(t reflect.Type) []Field {
+	fields := []Field{}
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, inline := fieldName(field)
+		if name == "-" {
+			continue
+		}
+
+		if inline {
+			fields = append(fields, Of(Indirect(field.Type))...)
+			continue
+		}
+
+		fields = append(fields, Field{Name: name, StructField: field})
+	}
+
+	return fields
+}
+
+// Indirect returns the type pointed by t, following all the pointers.
+func Indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	return t
+}
+
+// fieldName returns the key used by the yaml library for the field and whether it is inlined.
+func fieldName(field reflect.StructField) (string, bool) {
+	name, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+
+	inline := false
+	for _, option := range strings.Split(options, ",") {
+		if option == "inline" {
+			inline = true
+		}
+	}
+
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+
+	return name, inline
+}
@@ -0,0 +1,44 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package yamlfields_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/newrelic/newrelic-prometheus-configurator/internal/yamlfields"
+	"github.com/stretchr/testify/assert"
+)
+
+type Inlined struct {
+	Inner string `yaml:"inner"`
+}
+
+type example struct {
+	*Inlined `yaml:",inline"`
+
+	Named     string `yaml:"named,omitempty"`
+	Untagged  string
+	Skipped   string `yaml:"-"`
+	unexposed string
+}
+
+func TestOf(t *testing.T) {
+	t.Parallel()
+
+	var names []string
+	for _, field := range yamlfields.Of(reflect.TypeOf(example{})) {
+		names = append(names, field.Name)
+	}
+
+	assert.Equal(t, []string{"inner", "named", "untagged"}, names)
+}
+
+func TestIndirect(t *testing.T) {
+	t.Parallel()
+
+	var value **example
+
+	assert.Equal(t, reflect.TypeOf(example{}), yamlfields.Indirect(reflect.TypeOf(value)))
+}